@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// maxAssertFileSize limits the files read by assert-file
+const maxAssertFileSize = 16 << 20
+
+var ErrInvalidAssertion = errors.New("Invalid assertion")
+
+// Assert checks the current state of the branch and records the outcome in
+// the history. A failed assertion is recorded with a non-zero exit code
+// like an evaluation, so it is not part of the build. Nothing is committed. An assert command must exit 0, assert-file expects the file
+// at `path [contains text]` to exist and assert-changed expects a committed
+// step to have changed a path matching the glob.
+func (w *Workspace) Assert(kind string, args string) (EvalResult, error) {
+	args = strings.TrimSpace(args)
+	res := EvalResult{
+		Command:   args,
+		Assert:    kind,
+		Mode:      w.Mode,
+		BaseImage: w.Image,
+		Image:     w.CurrentImage,
+	}
+	if args == "" {
+		return res, ErrInvalidAssertion
+	}
+
+	var passed bool
+	var err error
+	switch kind {
+	case "assert":
+		passed, err = w.assertCommand(&res)
+	case "assert-file":
+		passed, err = w.assertFile(args)
+	case "assert-changed":
+		passed, err = w.assertChanged(args)
+	default:
+		return res, ErrInvalidAssertion
+	}
+	if err != nil {
+		return res, err
+	}
+	if !passed {
+		if res.Code == 0 {
+			res.Code = 1
+		}
+		res.Deleted = true
+	}
+	w.add(res)
+	return res, nil
+}
+
+// assertCommand evaluates the command of res, keeping its exit code and
+// output. The container is removed since its changes are never committed.
+func (w *Workspace) assertCommand(res *EvalResult) (bool, error) {
+	eval, err := w.evalCommand(res.Command)
+	if eval.Id != "" {
+		RemoveContainer(w.docker, eval.Id)
+	}
+	if err != nil {
+		return false, err
+	}
+	res.Code = eval.Code
+	res.Duration = eval.Duration
+	res.Log = eval.Log
+	res.Changes = eval.Changes
+	res.Interrupted = eval.Interrupted
+	return eval.Code == 0 && eval.Interrupted == "", nil
+}
+
+func (w *Workspace) assertFile(args string) (bool, error) {
+	path, text, err := parseAssertFile(args)
+	if err != nil {
+		return false, err
+	}
+	content, found, err := ReadImageFile(w.docker, w.CurrentImage, path, maxAssertFileSize)
+	if err == ErrNotRegularFile && text == "" {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return found && strings.Contains(string(content), text), nil
+}
+
+// assertChanged looks for a change matching glob, or below a matching
+// directory, in the committed steps of the current branch
+func (w *Workspace) assertChanged(glob string) (bool, error) {
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return false, err
+	}
+	patterns := cleanPatterns([]string{glob})
+	for _, entry := range w.path() {
+		if entry.NewImage == "" {
+			continue
+		}
+		changes, _ := w.filterChanges(entry.Changes)
+		for _, change := range changes {
+			if matchPath(change.Path, patterns) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// parseAssertFile splits `path [contains text]`
+func parseAssertFile(args string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 3)
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return parts[0], "", nil
+	case len(parts) == 3 && parts[1] == "contains" && strings.TrimSpace(parts[2]) != "":
+		return parts[0], strings.TrimSpace(parts[2]), nil
+	}
+	return "", "", ErrInvalidAssertion
+}
+
+// assertTest returns a shell command testing the assertion of r, or an
+// empty string when it can't be tested in a build
+func assertTest(r EvalResult) string {
+	switch r.Assert {
+	case "assert":
+		if _, ok := modeFor(r.Mode).(shellMode); ok {
+			return r.Command
+		}
+		return shellJoin(modeFor(r.Mode).Cmd(r.Command))
+	case "assert-file":
+		path, text, err := parseAssertFile(r.Command)
+		if err != nil {
+			return ""
+		}
+		if text == "" {
+			return "test -e " + shellQuote(path)
+		}
+		return "grep -qF -- " + shellQuote(text) + " " + shellQuote(path)
+	}
+	return ""
+}
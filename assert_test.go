@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssert(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	mockdock.Changes = []docker.Change{{Path: "/etc/nginx/nginx.conf", Kind: 1}}
+	ws.Run("apt-get install -y nginx")
+	mockdock.Changes = nil
+	mockdock.Files["i1"] = map[string]string{"/etc/nginx/nginx.conf": "server {\n  listen 80;\n}\n"}
+
+	res, err := ws.Assert("assert", "nginx -t")
+	assert.NoError(err)
+	assert.Equal(0, res.Code)
+	assert.Equal("", ws.history[1].Id)
+	assert.Len(mockdock.Containers, 1)
+
+	res, err = ws.Assert("assert-file", "/etc/nginx/nginx.conf contains listen 80")
+	assert.NoError(err)
+	assert.Equal(0, res.Code)
+	res, err = ws.Assert("assert-file", "/etc/nginx/nginx.conf contains listen 443")
+	assert.NoError(err)
+	assert.Equal(1, res.Code)
+	res, err = ws.Assert("assert-file", "/etc/nginx/sites-enabled/default")
+	assert.NoError(err)
+	assert.Equal(1, res.Code)
+
+	res, err = ws.Assert("assert-changed", "/etc/nginx")
+	assert.NoError(err)
+	assert.Equal(0, res.Code)
+	res, err = ws.Assert("assert-changed", "/etc/apache2/*")
+	assert.NoError(err)
+	assert.Equal(1, res.Code)
+
+	_, err = ws.Assert("assert-file", "/etc/nginx/nginx.conf has listen")
+	assert.Equal(ErrInvalidAssertion, err)
+	_, err = ws.Assert("assert", "")
+	assert.Equal(ErrInvalidAssertion, err)
+
+	assert.Len(ws.history, 7)
+	assert.Equal("i1", ws.CurrentImage)
+	assert.Equal("i1", ws.imageAt(ws.head))
+}
+
+func TestAssertRender(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	mockdock.Changes = []docker.Change{{Path: "/etc/nginx/nginx.conf", Kind: 1}}
+	ws.Run("apt-get install -y nginx")
+	mockdock.Changes = nil
+	mockdock.Files["i1"] = map[string]string{"/etc/nginx/nginx.conf": "listen 80;\n"}
+	ws.Assert("assert", "nginx -t")
+	ws.Assert("assert-file", "/etc/nginx/nginx.conf contains listen 80")
+	ws.Assert("assert-changed", "/etc/nginx")
+
+	state, err := ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{
+		"FROM ubuntu:trusty",
+		"RUN apt-get install -y nginx",
+		"RUN nginx -t",
+		"RUN grep -qF -- 'listen 80' /etc/nginx/nginx.conf",
+		"# :assert-changed /etc/nginx",
+	}, state)
+
+	script, err := ws.SprintWith(RenderOptions{Format: "sh"})
+	assert.NoError(err)
+	assert.Contains(strings.Join(script, "\n"), "# :assert-file /etc/nginx/nginx.conf contains listen 80\ngrep -qF -- 'listen 80' /etc/nginx/nginx.conf\n")
+	assert.Equal("# :assert-changed /etc/nginx", script[len(script)-1])
+}
+
+func TestAssertFailedNotWritten(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+
+	ws.Run("apt-get install -y nginx")
+	head := ws.head
+	res, err := ws.Assert("assert-file", "/etc/nginx/nginx.conf")
+	assert.NoError(err)
+	assert.Equal(1, res.Code)
+	assert.True(ws.history[1].Deleted)
+	assert.Equal(head, ws.head)
+
+	assert.NoError(ws.Write(".test/Dockerfile"))
+	content, _ := ioutil.ReadFile(".test/Dockerfile")
+	assert.Equal("FROM ubuntu:trusty\nRUN apt-get install -y nginx\n", string(content))
+}
+
+func TestParseAssertFile(t *testing.T) {
+	assert := assert.New(t)
+
+	path, text, err := parseAssertFile("/etc/hosts contains localhost  ")
+	assert.NoError(err)
+	assert.Equal("/etc/hosts", path)
+	assert.Equal("localhost", text)
+
+	path, text, err = parseAssertFile("/etc/hosts")
+	assert.NoError(err)
+	assert.Equal("/etc/hosts", path)
+	assert.Equal("", text)
+
+	_, _, err = parseAssertFile("/etc/hosts contains")
+	assert.Equal(ErrInvalidAssertion, err)
+}
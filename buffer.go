@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
+	"io/ioutil"
 )
 
 // implements io.Writer to receive streaming logs
@@ -33,3 +35,24 @@ func (b *Buffer) WriteString(s string) (n int, err error) {
 func (b *Buffer) Bytes() []byte {
 	return b.buf.Bytes()
 }
+
+// MarshalJSON encodes the captured output as a plain string
+// so the Buffer can be persisted with its EvalResult
+func (b *Buffer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.buf.String())
+}
+
+// UnmarshalJSON restores captured output; the restored Buffer
+// no longer streams to a writer
+func (b *Buffer) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b.buf.Reset()
+	b.buf.WriteString(s)
+	if b.writer == nil {
+		b.writer = ioutil.Discard
+	}
+	return nil
+}
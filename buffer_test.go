@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,3 +40,25 @@ func TestBufferWrite(t *testing.T) {
 	assert.Equal(string(byt), "banana banana")
 	assert.Equal(string(writerbyt), "banana banana")
 }
+
+func TestBufferJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	var writer bytes.Buffer
+	buf := NewBuffer(&writer)
+	buf.WriteString("banana\nbanana")
+
+	out, err := json.Marshal(buf)
+	assert.NoError(err)
+	assert.Equal(`"banana\nbanana"`, string(out))
+
+	restored := &Buffer{}
+	err = json.Unmarshal(out, restored)
+	assert.NoError(err)
+	assert.Equal("banana\nbanana", string(restored.Bytes()))
+
+	assert.NotPanics(func() {
+		restored.WriteString(" banana")
+	})
+	assert.Equal("banana\nbanana banana", string(restored.Bytes()))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// cacheFile persists the layer cache between sessions, in the home directory
+// since the cached images are only reused by the same user
+const cacheFile = ".cyclops_cache.json"
+
+// cachePath returns the cache file in the home directory, or an empty path
+// to keep the cache in memory when there is no home directory
+func cachePath(getenv func(string) string) string {
+	home := getenv("HOME")
+	if home == "" {
+		home = getenv("USERPROFILE")
+	}
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, cacheFile)
+}
+
+// cachedStep is the outcome of a committed :run, reused for identical steps
+type cachedStep struct {
+	Image   string          `json:"image"` //committed image
+	Changes []docker.Change `json:"changes"`
+	Log     *Buffer         `json:"log"`
+}
+
+// layerCache maps the key of a step, see stepKey, to the image it
+// committed. Images are verified before they are reused.
+type layerCache struct {
+	path  string //file the cache is saved to, empty to keep it in memory
+	Steps map[string]cachedStep
+}
+
+func newLayerCache(path string) *layerCache {
+	return &layerCache{path: path, Steps: map[string]cachedStep{}}
+}
+
+// loadCache reads the cache saved at path; a missing file is an empty cache
+func loadCache(path string) (*layerCache, error) {
+	cache := newLayerCache(path)
+	if path == "" {
+		return cache, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return newLayerCache(path), err
+	}
+	if cache.Steps == nil {
+		cache.Steps = map[string]cachedStep{}
+	}
+	return cache, nil
+}
+
+// save writes the cache to a temporary file that replaces the cache file,
+// so concurrent sessions never read a partly written cache
+func (c *layerCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+	out, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.path), cacheFile)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(out)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// reload replaces the steps with the ones saved by now, so changes other
+// sessions made since the cache was loaded aren't overwritten
+func (c *layerCache) reload() {
+	if c.path == "" {
+		return
+	}
+	if saved, err := loadCache(c.path); err == nil {
+		c.Steps = saved.Steps
+	}
+}
+
+// put caches the image committed by res. The log is copied so the cache
+// doesn't share the Buffer of the history entry.
+func (c *layerCache) put(key string, res EvalResult) error {
+	step := cachedStep{Image: res.NewImage, Changes: res.Changes}
+	if res.Log != nil {
+		step.Log = NewBuffer(ioutil.Discard)
+		step.Log.Write(res.Log.Bytes())
+	}
+	c.reload()
+	c.Steps[key] = step
+	return c.save()
+}
+
+func (c *layerCache) remove(key string) error {
+	c.reload()
+	delete(c.Steps, key)
+	return c.save()
+}
+
+// clear empties the cache and returns the number of steps removed. The
+// cached images are left to docker.
+func (c *layerCache) clear() (int, error) {
+	n := len(c.Steps)
+	c.Steps = map[string]cachedStep{}
+	return n, c.save()
+}
+
+// usesWork reports whether a step may read /work: its command refers to it
+// or it runs there
+func usesWork(command string, workdir string) bool {
+	return strings.Contains(command, "/work") || workdir == "/work" || strings.HasPrefix(workdir, "/work/")
+}
+
+// stepKey identifies a step by the ID of the image it runs on, the
+// container it runs in and the config committed with it
+func stepKey(d DockerService, image string, options docker.CreateContainerOptions, config *docker.Config) (string, error) {
+	parent, err := d.InspectImage(image)
+	if err != nil {
+		return "", err
+	}
+	id := parent.ID
+	if id == "" {
+		id = image
+	}
+	data, err := json.Marshal([]interface{}{id, options, config})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// runCached looks up a previous run of command on the current image. The
+// step is returned as committed from the cache; cached images that no
+// longer exist are dropped from the cache.
+func (w *Workspace) runCached(command string, key string) (EvalResult, bool) {
+	step, ok := w.cache.Steps[key]
+	if !ok || w.NoCache {
+		return EvalResult{}, false
+	}
+	if err := verifyImage(w.docker, step.Image); err != nil {
+		w.cache.remove(key)
+		return EvalResult{}, false
+	}
+	res := EvalResult{
+		Command:   command,
+		Mode:      w.Mode,
+		BaseImage: w.Image,
+		Image:     w.CurrentImage,
+		NewImage:  step.Image,
+		Changes:   step.Changes,
+		Log:       step.Log,
+		Cached:    true,
+	}
+	w.CurrentImage = step.Image
+	w.warm()
+	return res, true
+}
+
+// runKey returns the cache key of running command on the current image,
+// or an empty string when the step can't be cached. Steps with bind mounts
+// or reading the current directory mounted at /work aren't cached since the
+// key can't account for the mounted files.
+func (w *Workspace) runKey(command string) string {
+	if len(w.Runtime.Binds) > 0 || usesWork(command, w.Config().WorkingDir) {
+		return ""
+	}
+	key, err := stepKey(w.docker, w.CurrentImage, evalContainer(command, w.CurrentImage, w.evalOptions(command)), w.Config())
+	if err != nil {
+		return ""
+	}
+	return key
+}
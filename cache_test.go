@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCached(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	res, err := ws.Run("apt-get update")
+	assert.NoError(err)
+	assert.False(res.Cached)
+	assert.NoError(ws.back(1))
+
+	res, err = ws.Run("apt-get update")
+	assert.NoError(err)
+	assert.True(res.Cached)
+	assert.Equal("i1", res.NewImage)
+	assert.Equal("i1", ws.CurrentImage)
+	assert.Equal("", res.Id)
+	assert.Len(mockdock.Created, 1)
+
+	// a different config is a different step
+	ws.back(1)
+	ws.Directive("ENV", "DEBIAN_FRONTEND=noninteractive")
+	res, err = ws.Run("apt-get update")
+	assert.NoError(err)
+	assert.False(res.Cached)
+	assert.Len(mockdock.Created, 2)
+
+	// images that are gone are run again
+	ws.back(1)
+	mockdock.MissingImages = map[string]bool{res.NewImage: true}
+	res, err = ws.Run("apt-get update")
+	assert.NoError(err)
+	assert.False(res.Cached)
+
+	ws.NoCache = true
+	ws.back(1)
+	res, err = ws.Run("apt-get update")
+	assert.NoError(err)
+	assert.False(res.Cached)
+}
+
+func TestRunNotCached(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	mockdock.PleaseReturn = 1
+	ws.Run("make")
+	mockdock.PleaseReturn = 0
+	res, _ := ws.Run("make")
+	assert.False(res.Cached)
+
+	mockdock.Hang = true
+	ws.Runtime.Set("timeout", "10ms")
+	ws.back(1)
+	res, _ = ws.Run("sleep 10")
+	assert.Equal("timeout", res.Interrupted)
+	assert.Len(ws.cache.Steps, 1)
+}
+
+func TestRunNotCachedWithBinds(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	assert.NoError(ws.Runtime.Set("bind", "/src:/src"))
+
+	ws.Run("make install")
+	ws.back(1)
+	res, err := ws.Run("make install")
+	assert.NoError(err)
+	assert.False(res.Cached)
+	assert.Len(ws.cache.Steps, 0)
+}
+
+func TestRunNotCachedWithWork(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	ws.Run("cp /work/app.conf /etc/app.conf")
+	ws.Directive("WORKDIR", "/work/src")
+	ws.Run("make install")
+	assert.Len(ws.cache.Steps, 0)
+
+	ws.Directive("WORKDIR", "/workspace")
+	ws.Run("make install")
+	assert.Len(ws.cache.Steps, 1)
+}
+
+func TestLayerCacheCopiesLog(t *testing.T) {
+	assert := assert.New(t)
+	cache := newLayerCache("")
+	log := NewBuffer(ioutil.Discard)
+	log.Write([]byte("built\n"))
+	assert.NoError(cache.put("key", EvalResult{NewImage: "i1", Log: log}))
+
+	log.Write([]byte("more\n"))
+	assert.Equal("built\n", string(cache.Steps["key"].Log.Bytes()))
+}
+
+func TestLayerCacheFile(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "cyclops")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	cache, err := loadCache(path)
+	assert.NoError(err)
+	assert.Len(cache.Steps, 0)
+	assert.NoError(cache.put("key", EvalResult{NewImage: "i1", Log: NewBuffer(ioutil.Discard)}))
+
+	cache, err = loadCache(path)
+	assert.NoError(err)
+	assert.Equal("i1", cache.Steps["key"].Image)
+	n, err := cache.clear()
+	assert.NoError(err)
+	assert.Equal(1, n)
+
+	cache, err = loadCache(path)
+	assert.NoError(err)
+	assert.Len(cache.Steps, 0)
+}
+
+func TestLayerCacheShared(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "cyclops")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, cacheFile)
+
+	// steps cached by another session since loading are kept
+	first, _ := loadCache(path)
+	second, _ := loadCache(path)
+	assert.NoError(first.put("one", EvalResult{NewImage: "i1"}))
+	assert.NoError(second.put("two", EvalResult{NewImage: "i2"}))
+
+	cache, err := loadCache(path)
+	assert.NoError(err)
+	assert.Len(cache.Steps, 2)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(files, 1)
+}
+
+func TestCachePath(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("/home/cyclops/.cyclops_cache.json", cachePath(env(map[string]string{"HOME": "/home/cyclops"})))
+	assert.Equal("", cachePath(env(map[string]string{})))
+
+	cache, err := loadCache("")
+	assert.NoError(err)
+	assert.NoError(cache.put("key", EvalResult{NewImage: "i1"}))
+	assert.Len(cache.Steps, 1)
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/peterh/liner"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1 // a step failed
+	exitUsage   = 2 // invalid command in a script
+)
+
+var (
+	ErrQuit            = errors.New("Quit")
+	ErrStepFailed      = errors.New("Command returned non-zero exit code")
+	ErrAssertionFailed = errors.New("Assertion failed")
+)
+
+// cli dispatches parsed commands to the Workspace, shared by the
+// interactive prompt and batch mode
+type cli struct {
+	ws             *Workspace
+	sessionPath    string
+	confirm        func(prompt string) bool
+	reporter       *reporter      //writes a report per command with --output=json
+	report         *commandReport //report of the command being executed
+	transcript     *Transcript    //records top level commands while set
+	transcriptPath string         //file the transcript is saved to
+}
+
+func newCli(ws *Workspace, sessionPath string) *cli {
+	return &cli{
+		ws:          ws,
+		sessionPath: sessionPath,
+		confirm:     func(string) bool { return true },
+	}
+}
+
+// interactive runs the liner prompt loop until quit or <ctrl-d>
+func (c *cli) interactive() {
+	line := liner.NewLiner()
+	defer line.Close()
+
+	prompt := defaultPrompt
+
+	if f, err := os.Open("/tmp/.cyclops_history"); err == nil {
+		line.ReadHistory(f)
+		f.Close()
+	}
+
+	c.confirm = func(message string) bool {
+		confirm, err := line.Prompt(message)
+		return err == nil && confirm == "y"
+	}
+
+	for {
+		input, err := line.Prompt(prompt + "> ")
+		if err == io.EOF {
+			fmt.Println() //Returns user to prompt on a new line
+			return
+		}
+		command, args, err := parseCommand(input)
+		if err != nil {
+			fmt.Println(err, command)
+			continue
+		}
+		if command == "" {
+			continue
+		}
+		if err := c.execute(command, args); err == ErrQuit {
+			return
+		}
+		if command == "help" {
+			continue
+		}
+
+		line.AppendHistory(input)
+		if f, err := os.Create("/tmp/.cyclops_history"); err != nil {
+			fmt.Println("error writing history:", err)
+		} else {
+			line.WriteHistory(f)
+			f.Close()
+		}
+	}
+}
+
+// batch executes each line read from r and returns the exit status.
+// Blank lines and lines starting with # are skipped. Execution stops at
+// the first failing step unless keepGoing is set.
+func (c *cli) batch(r io.Reader, keepGoing bool) int {
+	status := exitOK
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" || strings.HasPrefix(input, "#") {
+			continue
+		}
+		fmt.Printf("%s> %s\n", defaultPrompt, input)
+		command, args, err := parseCommand(input)
+		if err != nil {
+			fmt.Println(err, command)
+			return exitUsage
+		}
+		err = c.execute(command, args)
+		if err == ErrQuit {
+			break
+		}
+		if err != nil {
+			status = exitFailure
+			if !keepGoing {
+				return status
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Println("error reading script:", err)
+		return exitFailure
+	}
+	return status
+}
+
+// execute runs a single command and prints its results. It returns an
+// error when the command failed, including a :run with non-zero exit code.
+func (c *cli) execute(command string, args string) (err error) {
+	ws := c.ws
+	defer c.autosave()
+
+	report := &commandReport{Command: command, Args: args}
+	parent := c.report
+	c.report = report
+	if parent == nil {
+		defer c.trapInterrupt()()
+	}
+	defer func() {
+		c.report = parent
+		if err != nil && err != ErrQuit {
+			report.Error = err.Error()
+		}
+		report.Image = ws.CurrentImage
+		c.reporter.emit(report)
+		if parent == nil && c.transcript != nil && command != "record" && command != "quit" {
+			c.record(report)
+		}
+	}()
+
+	switch command {
+	case "help":
+		help()
+	case "quit":
+		return ErrQuit
+	case "commit":
+		id, err := ws.CommitLast()
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		fmt.Println("Committed:", id)
+	case "eval":
+		res, err := ws.Eval(args)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		c.printResults(res)
+	case "from":
+		if ws.CurrentImage != ws.Image {
+			if !c.confirm("Changes will be lost. Continue? <y>: ") {
+				fmt.Println("Aborted")
+				return nil
+			}
+			fmt.Println("Wiping history to set new base image")
+		}
+		ws.Reset()
+		if err := ws.SetImage(args); err != nil {
+			fmt.Println("error setting image:", err)
+			return err
+		}
+		fmt.Println("Image: ", args)
+	case "back":
+		num, err := strconv.Atoi(args)
+		if err != nil {
+			fmt.Println("Error: invalid number specified")
+			return err
+		}
+		if err := ws.back(num); err != nil {
+			fmt.Println("Error:", err)
+			return err
+		}
+		fmt.Printf("Back %d to %s\n", num, ws.CurrentImage)
+	case "history":
+		if opts, _ := parseOptions(args); opts["tree"] == "true" {
+			printTree(ws)
+			return nil
+		}
+		report.History = ws.history
+		printHistory(ws.history, ws.CurrentImage)
+	case "shell":
+		res, err := c.shell(args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return err
+		}
+		c.printResults(res)
+		if len(res.Changes) == 0 || !c.confirm("Commit changes? <y>: ") {
+			return nil
+		}
+		id, err := ws.CommitLast()
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		fmt.Println("Committed:", id)
+	case "diff":
+		lines, err := ws.Diff(args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return err
+		}
+		report.Lines = lines
+		printDiff(lines)
+	case "assert", "assert-file", "assert-changed":
+		res, err := ws.Assert(command, args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return err
+		}
+		report.Result = &res
+		printAssertion(res)
+		if res.Code != 0 {
+			return ErrAssertionFailed
+		}
+	case "record":
+		if args == "" {
+			if c.transcript == nil {
+				fmt.Println("Not recording")
+				return nil
+			}
+			fmt.Printf("Recorded %d commands to %s\n", len(c.transcript.Steps), c.transcriptPath)
+			c.transcript, c.transcriptPath = nil, ""
+			return nil
+		}
+		transcript := newTranscript(ws)
+		if err := transcript.Save(args); err != nil {
+			fmt.Println("Error saving transcript:", err)
+			return err
+		}
+		c.transcript, c.transcriptPath = transcript, args
+		fmt.Printf("Recording to %s from %s\n", args, ws.CurrentImage)
+		if ws.head > -1 {
+			fmt.Println("Earlier steps are not recorded, the replay starts from this image")
+		}
+	case "cache":
+		switch args {
+		case "":
+			fmt.Println("Cached steps:", len(ws.cache.Steps))
+		case "clear":
+			n, err := ws.cache.clear()
+			if err != nil {
+				fmt.Println("Error clearing cache:", err)
+				return err
+			}
+			fmt.Printf("Removed %d cached steps\n", n)
+		default:
+			fmt.Println("Usage: `:cache [clear]`")
+			return ErrInvalidCommand
+		}
+	case "service":
+		return c.service(args)
+	case "matrix":
+		images, command := parseMatrix(args)
+		if command == "" {
+			fmt.Println("Missing command: `:matrix [image,...] [command ...]`")
+			return ErrMissingRequiredArg
+		}
+		results, err := ws.Matrix(images, command, os.Stdout)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return err
+		}
+		report.Matrix = results
+		printMatrix(results)
+		for _, r := range results {
+			if r.Error != "" || r.Result.Code != 0 {
+				return ErrStepFailed
+			}
+		}
+	case "changes":
+		if len(ws.history) == 0 {
+			fmt.Println("No command run yet")
+			return nil
+		}
+		printChanges(ws.filterChanges(ws.history[len(ws.history)-1].Changes))
+	case "ignore":
+		if args != "" {
+			if err := ws.Ignore(strings.Fields(args)...); err != nil {
+				fmt.Println("Error:", err)
+				return err
+			}
+		}
+		fmt.Println("Ignored:", strings.Join(ws.ignore, " "))
+	case "mark":
+		if args == "" {
+			for i := -1; i < len(ws.history); i++ {
+				for _, name := range ws.marksAt(i) {
+					fmt.Printf("%s\t%s\n", name, shortId(ws.imageAt(i)))
+				}
+			}
+			return nil
+		}
+		if err := ws.Mark(args); err != nil {
+			fmt.Println(err, args)
+			return err
+		}
+		fmt.Printf("Marked: %s at %s\n", args, shortId(ws.CurrentImage))
+	case "checkout":
+		if err := ws.Checkout(args); err != nil {
+			fmt.Println("Error:", err)
+			return err
+		}
+		fmt.Println("Checked out:", shortId(ws.CurrentImage))
+	case "print":
+		opts, _ := parseOptions(args)
+		out, err := ws.SprintWith(renderOptions(opts))
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		report.Lines = out
+		for _, line := range out {
+			fmt.Println(line)
+		}
+	case "run":
+		res, err := ws.Run(args)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		c.printResults(res)
+		if res.Code != 0 {
+			return ErrStepFailed
+		}
+	case "write":
+		opts, paths := parseOptions(args)
+		if len(paths) != 1 {
+			fmt.Println("Missing file path: `:write [--format=sh] [path/to/file]`")
+			return ErrMissingRequiredArg
+		}
+		if err := ws.WriteWith(paths[0], renderOptions(opts)); err != nil {
+			fmt.Println("Error writing file:", err)
+			return err
+		}
+		fmt.Println("File written:", paths[0])
+	case "env", "workdir", "user", "expose", "cmd", "entrypoint", "label", "volume":
+		res, err := ws.Directive(directives[command], args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return err
+		}
+		fmt.Println(res.Instruction())
+	case "copy":
+		paths := strings.Fields(args)
+		if len(paths) != 2 {
+			fmt.Println("Usage: `:copy [src] [dest]`")
+			return ErrMissingRequiredArg
+		}
+		res, err := ws.Copy(paths[0], paths[1])
+		if err != nil {
+			fmt.Println("Error:", err)
+			return err
+		}
+		c.printResults(res)
+	case "tag":
+		image, err := ws.Tag(args)
+		if err != nil {
+			fmt.Println("Error tagging image:", err)
+			return err
+		}
+		fmt.Printf("Tagged: %s as %s\n", shortId(image), args)
+	case "save":
+		if err := ws.Export(args); err != nil {
+			fmt.Println("Error saving image:", err)
+			return err
+		}
+		fmt.Println("Image saved:", args)
+	case "squash":
+		opts, names := parseOptions(args)
+		fmt.Printf("Squashed %d steps\n", ws.Squash())
+		if opts["flatten"] != "true" {
+			return nil
+		}
+		var name string
+		if len(names) > 0 {
+			name = names[0]
+		}
+		image, err := ws.Flatten(name)
+		if err != nil {
+			fmt.Println("Error flattening image:", err)
+			return err
+		}
+		fmt.Println("Flattened:", shortId(image))
+	case "set":
+		for _, field := range strings.Fields(args) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) < 2 {
+				kv = append(kv, "true")
+			}
+			if err := ws.Runtime.Set(kv[0], kv[1]); err != nil {
+				fmt.Println("Error:", err, kv[0])
+				fmt.Println("Options:", strings.Join(runtimeOptions, ", "))
+				return err
+			}
+		}
+		printConfig(ws)
+	case "config":
+		printConfig(ws)
+	case "mode":
+		if args == "" {
+			fmt.Println("Mode:", ws.Mode)
+			fmt.Println("Available:", strings.Join(modeNames(), ", "))
+			return nil
+		}
+		if err := ws.SetMode(args); err != nil {
+			fmt.Println(err, args)
+			return err
+		}
+		fmt.Println("Mode:", args)
+	case "import":
+		instructions, err := readDockerfile(args)
+		if err != nil {
+			fmt.Println("Error reading Dockerfile:", err)
+			return err
+		}
+		// RUN instructions are shell commands whatever the current mode is
+		mode := ws.Mode
+		ws.Mode = "sh"
+		defer func() { ws.Mode = mode }()
+		for n, inst := range instructions {
+			command, cmdArgs, ok := inst.Command()
+			if !ok {
+				fmt.Printf("Step %d: skipping unsupported instruction %s\n", n+1, inst.Name)
+				continue
+			}
+			fmt.Printf("Step %d: %s %s\n", n+1, inst.Name, inst.Args)
+			if err := c.execute(command, cmdArgs); err != nil {
+				fmt.Printf("Import stopped at step %d\n", n+1)
+				return err
+			}
+		}
+		fmt.Println("Imported:", args)
+	case "session":
+		action, path, err := parseSessionArgs(args, c.sessionPath)
+		if err != nil {
+			fmt.Println("Usage: `:session [save|load] [path/to/file]`")
+			return err
+		}
+		if action == "save" {
+			if err := ws.Save(path); err != nil {
+				fmt.Println("Error saving session:", err)
+				return err
+			}
+			fmt.Println("Session saved:", path)
+			return nil
+		}
+		// the current state is only replaced once the session could be read
+		session, err := readSession(path)
+		if err == nil {
+			err = ws.ensureImage(session.Image)
+		}
+		if err != nil {
+			fmt.Println("Error loading session:", err)
+			return err
+		}
+		ws.Reset()
+		dropped := ws.restore(session)
+		fmt.Println("Session loaded:", path)
+		if dropped > 0 {
+			fmt.Printf("Dropped %d steps with missing images\n", dropped)
+		}
+	default:
+		return ErrInvalidCommand
+	}
+	return nil
+}
+
+// shell runs an interactive session with the terminal in raw mode,
+// propagating window size changes to the container
+func (c *cli) shell(cmd string) (EvalResult, error) {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return EvalResult{}, errors.New(":shell requires a terminal")
+	}
+	fd := os.Stdin.Fd()
+	resize := make(chan [2]int, 1)
+	if size, err := terminalSize(fd); err == nil {
+		resize <- size
+	}
+	sigs := make(chan os.Signal, 1)
+	notifyResize(sigs)
+	go func() {
+		for range sigs {
+			if size, err := terminalSize(fd); err == nil {
+				select {
+				case resize <- size:
+				default:
+				}
+			}
+		}
+	}()
+	defer func() {
+		signal.Stop(sigs)
+		close(sigs)
+	}()
+
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return EvalResult{}, err
+	}
+	res, err := c.ws.Shell(cmd, os.Stdin, os.Stdout, resize)
+	restore()
+	return res, err
+}
+
+// trapInterrupt makes <ctrl-c> stop the running command instead of
+// cyclops, until the returned function is called
+func (c *cli) trapInterrupt() func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	cancel := make(chan struct{})
+	c.ws.cancel = cancel
+	go func() {
+		if _, ok := <-sigs; ok {
+			close(cancel)
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+		c.ws.cancel = nil
+	}
+}
+
+// printResults prints res with a summary of the packages it changed
+func (c *cli) printResults(res EvalResult) {
+	packages, err := c.ws.Packages(res)
+	if err != nil {
+		fmt.Println("error reading package databases:", err)
+	}
+	var hidden int
+	res.Changes, hidden = c.ws.filterChanges(res.Changes)
+	if c.report != nil {
+		c.report.Result = &res
+		c.report.Packages = packages
+	}
+	printResults(res, packages, hidden)
+}
+
+// cleanup removes the containers created in the session before exiting
+func (c *cli) cleanup() {
+	report := &commandReport{Command: "cleanup"}
+	for _, res := range preExit(c.ws) {
+		if res.Err != nil {
+			report.Error = res.Err.Error()
+		} else {
+			report.Removed = append(report.Removed, res.Id)
+		}
+	}
+	report.Image = c.ws.CurrentImage
+	c.reporter.emit(report)
+}
+
+// service runs the :service subcommands
+func (c *cli) service(args string) error {
+	ws := c.ws
+	action, rest := nextField(args)
+	switch action {
+	case "start":
+		name, ports, command := parseService(rest)
+		if name == "" || command == "" {
+			fmt.Println("Usage: `:service start name [--publish=host:container ...] command`")
+			return ErrMissingRequiredArg
+		}
+		svc, err := ws.StartService(name, command, ports)
+		if err != nil {
+			fmt.Println("Error starting service:", err)
+			return err
+		}
+		fmt.Printf("Started service %s: %s\n", svc.Name, shortId(svc.Id))
+		if ws.Runtime.Network != "" && ws.Runtime.Network != "bridge" {
+			fmt.Println("Services can't be reached by name with network=" + ws.Runtime.Network)
+		}
+		c.report.Services = ws.Services()
+	case "stop":
+		svc, err := ws.StopService(strings.TrimSpace(rest))
+		if err != nil {
+			fmt.Println("Error stopping service:", err)
+			return err
+		}
+		fmt.Println("Stopped service", svc.Name)
+		c.report.Services = ws.Services()
+	case "logs":
+		if err := ws.ServiceLogs(strings.TrimSpace(rest), os.Stdout); err != nil {
+			fmt.Println("Error reading logs:", err)
+			return err
+		}
+	case "list", "":
+		c.report.Services = ws.Services()
+		printServices(ws.Services())
+	default:
+		fmt.Println("Usage: `:service start|stop|logs|list`")
+		return ErrInvalidCommand
+	}
+	return nil
+}
+
+// parseOptions splits command arguments into --key=value options and the
+// remaining positional arguments. Options without a value are set to "true".
+func parseOptions(args string) (map[string]string, []string) {
+	opts := map[string]string{}
+	rest := []string{}
+	for _, field := range strings.Fields(args) {
+		if !strings.HasPrefix(field, "--") {
+			rest = append(rest, field)
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(field, "--"), "=", 2)
+		if len(kv) == 2 {
+			opts[kv[0]] = kv[1]
+		} else {
+			opts[kv[0]] = "true"
+		}
+	}
+	return opts, rest
+}
+
+func renderOptions(opts map[string]string) RenderOptions {
+	return RenderOptions{
+		Format: opts["format"],
+		Squash: opts["squash"] == "true",
+	}
+}
+
+// autosave writes the session file when cyclops was started with --session
+func (c *cli) autosave() {
+	if c.sessionPath == "" {
+		return
+	}
+	if err := c.ws.Save(c.sessionPath); err != nil {
+		fmt.Println("error saving session:", err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+
+	script := `# comment
+:run cmd1
+
+cmd2
+:env FOO=bar
+:run cmd3
+`
+	status := c.batch(strings.NewReader(script), false)
+	assert.Equal(exitOK, status)
+	assert.Len(ws.history, 4)
+	assert.Equal("i3", ws.CurrentImage)
+}
+
+func TestBatchQuit(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+
+	status := c.batch(strings.NewReader(":run cmd1\n:quit\n:run cmd2\n"), false)
+	assert.Equal(exitOK, status)
+	assert.Len(ws.history, 1)
+}
+
+func TestBatchInvalidCommand(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+
+	status := c.batch(strings.NewReader(":run cmd1\n:notreal\n:run cmd2\n"), true)
+	assert.Equal(exitUsage, status)
+	assert.Len(ws.history, 1)
+}
+
+func TestBatchFailure(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	mockdock.PleaseReturn = 1
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+
+	status := c.batch(strings.NewReader(":run cmd1\n:run cmd2\n"), false)
+	assert.Equal(exitFailure, status)
+	assert.Len(ws.history, 1)
+
+	ws = NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	c = newCli(ws, "")
+	status = c.batch(strings.NewReader(":run cmd1\n:run cmd2\n"), true)
+	assert.Equal(exitFailure, status)
+	assert.Len(ws.history, 2)
+}
+
+func TestBatchEvalIsNotFailure(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	mockdock.PleaseReturn = 1
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+
+	status := c.batch(strings.NewReader("cmd1\ncmd2\n"), false)
+	assert.Equal(exitOK, status)
+	assert.Len(ws.history, 2)
+}
+
+func TestBatchAssertionFailure(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+
+	status := c.batch(strings.NewReader(":run cmd1\n:assert-file /etc/nginx/nginx.conf\n:run cmd2\n"), false)
+	assert.Equal(exitFailure, status)
+	assert.Len(ws.history, 2)
+	assert.Equal(1, ws.history[1].Code)
+}
+
+func TestImport(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+	dockerfile := "FROM debian:jessie\nMAINTAINER cyclops\nRUN cmd1\nENV FOO=bar\nWORKDIR /app\nRUN cmd2\n"
+	ioutil.WriteFile(".test/Dockerfile", []byte(dockerfile), 0644)
+
+	assert.NoError(c.execute("import", ".test/Dockerfile"))
+	assert.Equal("debian:jessie", ws.Image)
+	assert.Equal("i2", ws.CurrentImage)
+
+	state, err := ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM debian:jessie", "RUN cmd1", "ENV FOO=bar", "WORKDIR /app", "RUN cmd2"}, state)
+	// RUN lines are run by the shell and the mode is kept
+	assert.Equal([]string{"/bin/sh", "-c", "cmd1"}, mockdock.Created[0].Config.Cmd)
+	assert.Equal("bash", ws.Mode)
+
+	assert.NoError(ws.back(2))
+	state, err = ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM debian:jessie", "RUN cmd1", "ENV FOO=bar"}, state)
+
+	assert.Error(c.execute("import", ".test/missing"))
+}
+
+func TestImportFailure(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	mockdock.PleaseReturn = 1
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+	ioutil.WriteFile(".test/Dockerfile", []byte("FROM debian:jessie\nRUN cmd1\nRUN cmd2\n"), 0644)
+
+	assert.Equal(ErrStepFailed, c.execute("import", ".test/Dockerfile"))
+	assert.Len(ws.history, 1)
+}
+
+func TestSessionLoadFailure(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+	c := newCli(ws, ".test/session.json")
+
+	assert.NoError(c.execute("run", "cmd1"))
+	assert.Error(c.execute("session", "load .test/missing.json"))
+	assert.Len(ws.history, 1)
+	assert.False(ws.history[0].Deleted)
+	assert.Equal("i1", ws.CurrentImage)
+
+	// the autosave still holds the history
+	loaded := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+	_, err := loaded.Load(".test/session.json")
+	assert.NoError(err)
+	assert.Equal("i1", loaded.CurrentImage)
+}
+
+func TestParseOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	opts, rest := parseOptions("--format=sh install.sh --squash")
+	assert.Equal(map[string]string{"format": "sh", "squash": "true"}, opts)
+	assert.Equal([]string{"install.sh"}, rest)
+
+	opts, rest = parseOptions("")
+	assert.Len(opts, 0)
+	assert.Len(rest, 0)
+}
+
+func TestBatchJSONOutput(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	mockdock.Outputs = map[string]string{"ubuntu:trusty": "done\n"}
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+	var out bytes.Buffer
+	c.reporter = newReporter(&out)
+
+	status := c.batch(strings.NewReader(":run cmd1\n:print\n:commit\n"), true)
+	assert.Equal(exitFailure, status)
+	c.cleanup()
+
+	reports := []commandReport{}
+	dec := json.NewDecoder(&out)
+	for {
+		var report commandReport
+		if err := dec.Decode(&report); err != nil {
+			break
+		}
+		reports = append(reports, report)
+	}
+	assert.Len(reports, 4)
+
+	assert.Equal("run", reports[0].Command)
+	assert.Equal("cmd1", reports[0].Args)
+	assert.Equal("i1", reports[0].Image)
+	assert.Equal("i1", reports[0].Result.NewImage)
+	assert.Equal(0, reports[0].Result.Code)
+	assert.Equal("done\n", string(reports[0].Result.Log.Bytes()))
+
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1"}, reports[1].Lines)
+	assert.Equal("Container already committed", reports[2].Error)
+
+	assert.Equal("cleanup", reports[3].Command)
+	assert.Equal([]string{"c1"}, reports[3].Removed)
+}
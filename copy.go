@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+)
+
+// Copy adds the local file or directory src to dest in a new layer on top
+// of the current image and commits it. dest follows COPY semantics: a
+// trailing slash or an existing directory copies a file into the directory,
+// relative paths are resolved against the configured WORKDIR.
+func (w *Workspace) Copy(src string, dest string) (EvalResult, error) {
+	res := EvalResult{
+		Directive: "COPY",
+		BaseImage: w.Image,
+		Image:     w.CurrentImage,
+	}
+
+	context, err := copyContext(src)
+	if err != nil {
+		return res, err
+	}
+	if strings.HasPrefix(context, "..") {
+		fmt.Println("Warning:", src, "is outside the current directory, the Dockerfile will not build from here")
+	}
+	res.Command = context + " " + dest
+
+	resolved := copyDest(dest, w.Config().WorkingDir)
+	if info, err := os.Stat(src); err == nil && !info.IsDir() && !strings.HasSuffix(resolved, "/") {
+		dir, err := isImageDir(w.docker, w.CurrentImage, resolved)
+		if err != nil {
+			return res, err
+		}
+		if dir {
+			resolved += "/"
+		}
+	}
+	tar, extractDir, err := tarSource(src, resolved)
+	if err != nil {
+		return res, err
+	}
+	defer tar.Close()
+
+	copied, err := Copy(w.docker, tar, extractDir, w.CurrentImage)
+	copied.Command = res.Command
+	copied.Directive = res.Directive
+	copied.BaseImage = res.BaseImage
+	if err != nil {
+		return copied, err
+	}
+	if copied.Code != 0 {
+		w.add(copied)
+		return copied, fmt.Errorf("copy failed with exit code %d", copied.Code)
+	}
+
+	copied.NewImage, err = w.commit(copied.Id)
+	w.add(copied)
+	return copied, err
+}
+
+// copyContext returns src relative to the current directory, which acts
+// as the build context for the written Dockerfile
+func copyContext(src string) (string, error) {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return "", err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(cwd, abs)
+}
+
+// copyDest resolves dest against workdir
+func copyDest(dest string, workdir string) string {
+	if path.IsAbs(dest) {
+		return dest
+	}
+	resolved := path.Join("/", workdir, dest)
+	if strings.HasSuffix(dest, "/") {
+		resolved += "/"
+	}
+	return resolved
+}
+
+// tarSource archives src so that unpacking it in the returned directory
+// places it at dest
+func tarSource(src string, dest string) (io.ReadCloser, string, error) {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return nil, "", err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, "", err
+	}
+	if info.IsDir() {
+		tar, err := archive.TarWithOptions(src, &archive.TarOptions{})
+		return tar, path.Clean(dest), err
+	}
+
+	options := &archive.TarOptions{
+		IncludeFiles: []string{filepath.Base(src)},
+	}
+	extractDir := path.Clean(dest)
+	if !strings.HasSuffix(dest, "/") {
+		extractDir = path.Dir(extractDir)
+		options.Name = path.Base(dest)
+	}
+	tar, err := archive.TarWithOptions(filepath.Dir(src), options)
+	return tar, extractDir, err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyDest(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("/etc/nginx.conf", copyDest("/etc/nginx.conf", "/app"))
+	assert.Equal("/app/nginx.conf", copyDest("nginx.conf", "/app"))
+	assert.Equal("/app/conf/", copyDest("conf/", "/app"))
+	assert.Equal("/conf", copyDest("conf", ""))
+}
+
+func TestTarSource(t *testing.T) {
+	assert := assert.New(t)
+
+	os.MkdirAll(".test/dir", 0755)
+	defer os.RemoveAll(".test")
+	ioutil.WriteFile(".test/dir/file", []byte("banana"), 0644)
+
+	tar, dir, err := tarSource(".test/dir", "/opt/dir/")
+	assert.NoError(err)
+	assert.Equal("/opt/dir", dir)
+	tar.Close()
+
+	tar, dir, err = tarSource(".test/dir/file", "/etc/")
+	assert.NoError(err)
+	assert.Equal("/etc", dir)
+	tar.Close()
+
+	tar, dir, err = tarSource(".test/dir/file", "/etc/renamed")
+	assert.NoError(err)
+	assert.Equal("/etc", dir)
+	tar.Close()
+
+	_, _, err = tarSource(".test/missing", "/etc/")
+	assert.Error(err)
+}
+
+func TestWorkflowCopy(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "dockerfile", "ubuntu:trusty")
+
+	os.MkdirAll(".test", 0755)
+	defer os.RemoveAll(".test")
+	ioutil.WriteFile(".test/file", []byte("banana"), 0644)
+
+	ws.Run("cmd1")
+	res, err := ws.Copy(".test/file", "/tmp/")
+	assert.NoError(err)
+	assert.Equal("i1", res.Image)
+	assert.Equal("i2", res.NewImage)
+	assert.Equal("i2", ws.CurrentImage)
+
+	_, err = ws.Copy(".test/missing", "/tmp/")
+	assert.Error(err)
+
+	state, err := ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1", "COPY .test/file /tmp/"}, state)
+
+	err = ws.back(1)
+	assert.NoError(err)
+	assert.Equal("i1", ws.CurrentImage)
+
+	// a file is copied into an existing directory without a trailing slash
+	mockdock.Outputs["i1"] = "dir\n"
+	_, err = ws.Copy(".test/file", "/opt/app")
+	assert.NoError(err)
+	created := mockdock.Created[len(mockdock.Created)-1]
+	assert.Equal("/opt/app", created.Config.Cmd[4])
+	_, err = ws.Copy(".test/file", "/opt/app")
+	assert.NoError(err)
+	created = mockdock.Created[len(mockdock.Created)-1]
+	assert.Equal("/opt", created.Config.Cmd[4])
+}
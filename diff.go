@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	maxDiffSize  = 1 << 20 // files larger than this are not diffed
+	maxDiffCells = 1 << 22 // limit on the lines compared, beyond it files are replaced whole
+	diffContext  = 3
+)
+
+// Diff returns a unified diff of the files changed by the last evaluated
+// command, comparing its container against the image it ran on. Only path
+// is compared when given.
+func (w *Workspace) Diff(path string) ([]string, error) {
+	entry, err := w.diffEntry()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{path}
+	if path == "" {
+		paths = []string{}
+		changes, _ := w.filterChanges(entry.Changes)
+		for _, change := range pruneChanges(changes) {
+			paths = append(paths, change.Path)
+		}
+	}
+
+	res := []string{}
+	for _, p := range paths {
+		lines, err := w.diffFile(entry, p)
+		if err != nil {
+			if path == "" && err == ErrNotRegularFile {
+				continue
+			}
+			return res, err
+		}
+		res = append(res, lines...)
+	}
+	return res, nil
+}
+
+// diffEntry returns the step Diff compares: the last command when it was
+// evaluated on the current state without being committed, otherwise the
+// head of the current branch
+func (w *Workspace) diffEntry() (*EvalResult, error) {
+	var entry *EvalResult
+	if n := len(w.history) - 1; n > -1 && w.history[n].Deleted && w.history[n].NewImage == "" && w.history[n].Parent == w.head {
+		entry = &w.history[n]
+	} else if w.head > -1 {
+		entry = &w.history[w.head]
+	}
+	if entry != nil && entry.Cached {
+		return nil, errors.New("The step was cached, no container to diff")
+	}
+	if entry == nil || entry.Id == "" {
+		return nil, errors.New("No container found to diff")
+	}
+	if _, err := w.docker.InspectContainer(entry.Id); err != nil {
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			return nil, errors.New("The container of the step was removed")
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (w *Workspace) diffFile(entry *EvalResult, path string) ([]string, error) {
+	before, inImage, err := ReadImageFile(w.docker, entry.Image, path, maxDiffSize)
+	if err == ErrFileTooLarge {
+		return []string{fmt.Sprintf("File %s is larger than %d bytes, not compared", path, maxDiffSize)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	after, inContainer, err := ReadFile(w.docker, entry.Id, path, maxDiffSize)
+	if err == ErrFileTooLarge {
+		return []string{fmt.Sprintf("File %s is larger than %d bytes, not compared", path, maxDiffSize)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !inImage && !inContainer {
+		return nil, fmt.Errorf("%s not found", path)
+	}
+
+	from, to := "a"+path, "b"+path
+	if !inImage {
+		from = "/dev/null"
+	}
+	if !inContainer {
+		to = "/dev/null"
+	}
+	if isBinary(before) || isBinary(after) {
+		if bytes.Equal(before, after) {
+			return nil, nil
+		}
+		return []string{fmt.Sprintf("Binary files %s and %s differ", from, to)}, nil
+	}
+	return unifiedDiff(splitLines(before), splitLines(after), from, to), nil
+}
+
+// isBinary uses the heuristic of git: content with a NUL byte in the first
+// 8000 bytes is binary
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) != -1
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// diffLine is a single line of an edit script: ' ' kept, '-' removed or
+// '+' added
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff formats the differences between a and b in unified diff
+// format, returning nothing when they are equal
+func unifiedDiff(a []string, b []string, from string, to string) []string {
+	script := editScript(a, b)
+
+	res := []string{}
+	for start := 0; start < len(script); {
+		// find the next change and the extent of its hunk
+		first := start
+		for first < len(script) && script[first].op == ' ' {
+			first++
+		}
+		if first == len(script) {
+			break
+		}
+		begin := first - diffContext
+		if begin < start {
+			begin = start
+		}
+		end := first
+		for kept := 0; end < len(script) && kept <= 2*diffContext; end++ {
+			if script[end].op == ' ' {
+				kept++
+			} else {
+				kept = 0
+			}
+		}
+		// trim trailing context to diffContext lines
+		for end > first && script[end-1].op == ' ' {
+			end--
+		}
+		end += diffContext
+		if end > len(script) {
+			end = len(script)
+		}
+
+		if len(res) == 0 {
+			res = append(res, "--- "+from, "+++ "+to)
+		}
+		res = append(res, hunkHeader(script, begin, end))
+		for _, line := range script[begin:end] {
+			res = append(res, string(line.op)+line.text)
+		}
+		start = end
+	}
+	return res
+}
+
+// hunkHeader returns the @@ line for script[begin:end]
+func hunkHeader(script []diffLine, begin int, end int) string {
+	aStart, bStart := 1, 1
+	for _, line := range script[:begin] {
+		if line.op != '+' {
+			aStart++
+		}
+		if line.op != '-' {
+			bStart++
+		}
+	}
+	aLen, bLen := 0, 0
+	for _, line := range script[begin:end] {
+		if line.op != '+' {
+			aLen++
+		}
+		if line.op != '-' {
+			bLen++
+		}
+	}
+	// an empty range starts at the line before it
+	if aLen == 0 {
+		aStart--
+	}
+	if bLen == 0 {
+		bStart--
+	}
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", aStart, aLen, bStart, bLen)
+}
+
+// editScript returns the lines of a and b as a longest common subsequence
+// edit script. Common leading and trailing lines are kept before comparing
+// the rest; if that is still too large the rest is replaced whole.
+func editScript(a []string, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	script := []diffLine{}
+	for _, line := range a[:prefix] {
+		script = append(script, diffLine{' ', line})
+	}
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(am)*len(bm) > maxDiffCells {
+		for _, line := range am {
+			script = append(script, diffLine{'-', line})
+		}
+		for _, line := range bm {
+			script = append(script, diffLine{'+', line})
+		}
+	} else {
+		script = append(script, lcsScript(am, bm)...)
+	}
+	for _, line := range a[len(a)-suffix:] {
+		script = append(script, diffLine{' ', line})
+	}
+	return script
+}
+
+func lcsScript(a []string, b []string) []diffLine {
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i > -1; i-- {
+		for j := len(b) - 1; j > -1; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	script := []diffLine{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			script = append(script, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			script = append(script, diffLine{'-', a[i]})
+			i++
+		default:
+			script = append(script, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		script = append(script, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		script = append(script, diffLine{'+', b[j]})
+	}
+	return script
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	a := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15"}
+	b := []string{"1", "2", "3", "4", "five", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15", "16"}
+	assert.Equal([]string{
+		"--- a/f",
+		"+++ b/f",
+		"@@ -2,7 +2,7 @@",
+		" 2",
+		" 3",
+		" 4",
+		"-5",
+		"+five",
+		" 6",
+		" 7",
+		" 8",
+		"@@ -13,3 +13,4 @@",
+		" 13",
+		" 14",
+		" 15",
+		"+16",
+	}, unifiedDiff(a, b, "a/f", "b/f"))
+
+	assert.Equal([]string{"--- /dev/null", "+++ b/f", "@@ -0,0 +1,1 @@", "+new"}, unifiedDiff([]string{}, []string{"new"}, "/dev/null", "b/f"))
+	assert.Len(unifiedDiff(a, a, "a/f", "b/f"), 0)
+}
+
+func TestWorkspaceDiff(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	_, err := ws.Diff("")
+	assert.Error(err)
+
+	mockdock.Files["ubuntu:trusty"] = map[string]string{
+		"/etc/hostname": "ubuntu\n",
+		"/bin/true":     "\x7fELF\x00",
+	}
+	mockdock.Files["c1"] = map[string]string{
+		"/etc/hostname": "cyclops\n",
+		"/etc/motd":     "hello\n",
+		"/bin/true":     "\x7fELF\x01\x00",
+	}
+	ws.Eval("cmd1")
+	ws.history[0].Changes = []docker.Change{
+		{Path: "/etc", Kind: 0},
+		{Path: "/etc/hostname", Kind: 0},
+		{Path: "/etc/motd", Kind: 1},
+		{Path: "/bin/true", Kind: 0},
+	}
+
+	lines, err := ws.Diff("/etc/hostname")
+	assert.NoError(err)
+	assert.Equal([]string{"--- a/etc/hostname", "+++ b/etc/hostname", "@@ -1,1 +1,1 @@", "-ubuntu", "+cyclops"}, lines)
+
+	lines, err = ws.Diff("")
+	assert.NoError(err)
+	assert.Equal([]string{
+		"--- a/etc/hostname", "+++ b/etc/hostname", "@@ -1,1 +1,1 @@", "-ubuntu", "+cyclops",
+		"--- /dev/null", "+++ b/etc/motd", "@@ -0,0 +1,1 @@", "+hello",
+		"Binary files a/bin/true and b/bin/true differ",
+	}, lines)
+
+	_, err = ws.Diff("/etc/shadow")
+	assert.Error(err)
+}
+
+func TestWorkspaceDiffHead(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	mockdock.Files["ubuntu:trusty"] = map[string]string{"/etc/hostname": "ubuntu\n"}
+	mockdock.Files["c1"] = map[string]string{"/etc/hostname": "one\n"}
+	mockdock.Files["c2"] = map[string]string{"/etc/hostname": "two\n"}
+
+	ws.Run("cmd1")
+	ws.Run("cmd2")
+	lines, err := ws.Diff("/etc/hostname")
+	assert.NoError(err)
+	assert.Equal("+two", lines[len(lines)-1])
+
+	// after going back the diff is of the new head, not the removed step
+	assert.NoError(ws.back(1))
+	lines, err = ws.Diff("/etc/hostname")
+	assert.NoError(err)
+	assert.Equal("+one", lines[len(lines)-1])
+
+	assert.NoError(ws.back(1))
+	_, err = ws.Diff("/etc/hostname")
+	assert.EqualError(err, "No container found to diff")
+
+	// cached steps have no container of their own
+	ws.Run("cmd1")
+	assert.True(ws.history[2].Cached)
+	_, err = ws.Diff("/etc/hostname")
+	assert.EqualError(err, "The step was cached, no container to diff")
+}
+
+func TestWorkspaceDiffRemoved(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	mockdock.Files["c1"] = map[string]string{"/etc/motd": "hello\n"}
+
+	ws.Eval("cmd1")
+	RemoveContainer(mockdock, "c1")
+	_, err := ws.Diff("/etc/motd")
+	assert.EqualError(err, "The container of the step was removed")
+
+	_, ok, err := ReadFile(mockdock, "c1", "/etc/motd", 1024)
+	assert.False(ok)
+	assert.IsType(&docker.NoSuchContainer{}, err)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var ErrInvalidDirective = errors.New("Invalid directive")
+
+// directives maps REPL commands to the Dockerfile instructions they emit
+var directives = map[string]string{
+	"env":        "ENV",
+	"workdir":    "WORKDIR",
+	"user":       "USER",
+	"expose":     "EXPOSE",
+	"cmd":        "CMD",
+	"entrypoint": "ENTRYPOINT",
+	"label":      "LABEL",
+	"volume":     "VOLUME",
+}
+
+// applyDirective updates config with a single Dockerfile instruction
+func applyDirective(config *docker.Config, instruction string, args string) error {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return ErrMissingRequiredArg
+	}
+	switch instruction {
+	case "ENV":
+		pairs, err := parseKeyValues(args)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			config.Env = setEnv(config.Env, pair[0], pair[1])
+		}
+	case "WORKDIR":
+		if path.IsAbs(args) {
+			config.WorkingDir = path.Clean(args)
+		} else {
+			config.WorkingDir = path.Join("/", config.WorkingDir, args)
+		}
+	case "USER":
+		config.User = args
+	case "EXPOSE":
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[docker.Port]struct{}{}
+		}
+		for _, port := range strings.Fields(args) {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			config.ExposedPorts[docker.Port(port)] = struct{}{}
+		}
+	case "CMD":
+		cmd, err := parseExecForm(args)
+		if err != nil {
+			return err
+		}
+		config.Cmd = cmd
+	case "ENTRYPOINT":
+		entrypoint, err := parseExecForm(args)
+		if err != nil {
+			return err
+		}
+		config.Entrypoint = entrypoint
+	case "LABEL":
+		pairs, err := parseKeyValues(args)
+		if err != nil {
+			return err
+		}
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+		for _, pair := range pairs {
+			config.Labels[pair[0]] = pair[1]
+		}
+	case "VOLUME":
+		var volumes []string
+		if strings.HasPrefix(args, "[") {
+			if err := json.Unmarshal([]byte(args), &volumes); err != nil {
+				return err
+			}
+		} else {
+			volumes = strings.Fields(args)
+		}
+		if config.Volumes == nil {
+			config.Volumes = map[string]struct{}{}
+		}
+		for _, volume := range volumes {
+			config.Volumes[volume] = struct{}{}
+		}
+	default:
+		return ErrInvalidDirective
+	}
+	return nil
+}
+
+// parseKeyValues parses `key=value key2="value 2"` pairs, or the legacy
+// single `key value` form used by ENV
+func parseKeyValues(args string) ([][2]string, error) {
+	fields := splitQuoted(args)
+	if len(fields) == 0 {
+		return nil, ErrMissingRequiredArg
+	}
+	if !strings.Contains(fields[0], "=") {
+		parts := strings.SplitN(args, " ", 2)
+		if len(parts) < 2 {
+			return nil, ErrMissingRequiredArg
+		}
+		return [][2]string{{parts[0], strings.TrimSpace(parts[1])}}, nil
+	}
+	pairs := [][2]string{}
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) < 2 || kv[0] == "" {
+			return nil, ErrInvalidDirective
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs, nil
+}
+
+// splitQuoted splits on whitespace, keeping double quoted values together
+// and stripping the quotes
+func splitQuoted(s string) []string {
+	fields := []string{}
+	var current []rune
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if len(current) > 0 {
+				fields = append(fields, string(current))
+				current = nil
+			}
+		default:
+			current = append(current, r)
+		}
+	}
+	if len(current) > 0 {
+		fields = append(fields, string(current))
+	}
+	return fields
+}
+
+// parseExecForm accepts both the JSON exec form and the shell form of
+// CMD and ENTRYPOINT
+func parseExecForm(args string) ([]string, error) {
+	if strings.HasPrefix(args, "[") {
+		var cmd []string
+		if err := json.Unmarshal([]byte(args), &cmd); err != nil {
+			return nil, err
+		}
+		return cmd, nil
+	}
+	return []string{"/bin/sh", "-c", args}, nil
+}
+
+// execForm formats cmd as the JSON exec form of a Dockerfile instruction
+func execForm(cmd []string) string {
+	out, _ := json.Marshal(cmd)
+	// json.Marshal escapes characters that are significant in html
+	replacer := strings.NewReplacer(`\u003c`, "<", `\u003e`, ">", `\u0026`, "&")
+	return replacer.Replace(string(out))
+}
+
+func setEnv(env []string, key string, value string) []string {
+	for i, e := range env {
+		if strings.SplitN(e, "=", 2)[0] == key {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyDirective(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &docker.Config{}
+	assert.NoError(applyDirective(config, "ENV", "FOO=bar BAZ=\"a b\""))
+	assert.NoError(applyDirective(config, "ENV", "FOO baz qux"))
+	assert.Equal([]string{"FOO=baz qux", "BAZ=a b"}, config.Env)
+
+	assert.NoError(applyDirective(config, "WORKDIR", "/app"))
+	assert.NoError(applyDirective(config, "WORKDIR", "src"))
+	assert.Equal("/app/src", config.WorkingDir)
+
+	assert.NoError(applyDirective(config, "USER", "nobody"))
+	assert.Equal("nobody", config.User)
+
+	assert.NoError(applyDirective(config, "EXPOSE", "80 53/udp"))
+	assert.Equal(map[docker.Port]struct{}{"80/tcp": {}, "53/udp": {}}, config.ExposedPorts)
+
+	assert.NoError(applyDirective(config, "CMD", `["nginx", "-g", "daemon off;"]`))
+	assert.Equal([]string{"nginx", "-g", "daemon off;"}, config.Cmd)
+
+	assert.NoError(applyDirective(config, "ENTRYPOINT", "top -b"))
+	assert.Equal([]string{"/bin/sh", "-c", "top -b"}, config.Entrypoint)
+
+	assert.NoError(applyDirective(config, "LABEL", "version=1.0"))
+	assert.Equal(map[string]string{"version": "1.0"}, config.Labels)
+
+	assert.NoError(applyDirective(config, "VOLUME", `["/data", "/logs"]`))
+	assert.NoError(applyDirective(config, "VOLUME", "/cache"))
+	assert.Equal(map[string]struct{}{"/data": {}, "/logs": {}, "/cache": {}}, config.Volumes)
+}
+
+func TestApplyDirectiveNegative(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &docker.Config{}
+	assert.Equal(ErrMissingRequiredArg, applyDirective(config, "USER", " "))
+	assert.Equal(ErrMissingRequiredArg, applyDirective(config, "ENV", "FOO"))
+	assert.Equal(ErrMissingRequiredArg, applyDirective(config, "ENV", `""`))
+	assert.Equal(ErrMissingRequiredArg, applyDirective(config, "LABEL", `""`))
+	assert.Equal(ErrInvalidDirective, applyDirective(config, "LABEL", "a=b =c"))
+	assert.Equal(ErrInvalidDirective, applyDirective(config, "ONBUILD", "RUN date"))
+	assert.Error(applyDirective(config, "CMD", "[not json"))
+}
+
+func TestExecForm(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`["/bin/sh","-c","a && b > \"c\""]`, execForm([]string{"/bin/sh", "-c", `a && b > "c"`}))
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
-	"path"
+	"strings"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
@@ -15,56 +22,197 @@ type DockerService interface {
 	CommitContainer(docker.CommitContainerOptions) (*docker.Image, error)
 	ContainerChanges(string) ([]docker.Change, error)
 	CreateContainer(docker.CreateContainerOptions) (*docker.Container, error)
+	InspectContainer(string) (*docker.Container, error)
 	RemoveContainer(docker.RemoveContainerOptions) error
 	StartContainer(string, *docker.HostConfig) error
 	WaitContainer(string) (int, error)
 	InspectImage(string) (*docker.Image, error)
+	TagImage(string, docker.TagImageOptions) error
+	ExportImage(docker.ExportImageOptions) error
+	ExportContainer(docker.ExportContainerOptions) error
+	ImportImage(docker.ImportImageOptions) error
+	CopyFromContainer(docker.CopyFromContainerOptions) error
+	ResizeContainerTTY(string, int, int) error
+	StopContainer(string, uint) error
+	PullImage(docker.PullImageOptions, docker.AuthConfiguration) error
+	Logs(docker.LogsOptions) error
 }
 
-func NewDockerClient(host string, tlsVerify string, certPath string) (client *docker.Client, err error) {
-	if host == "" {
-		return nil, errors.New("DOCKER_HOST must be set")
-	}
-
-	if tlsVerify == "yes" || tlsVerify == "1" {
-		if certPath == "" {
-			return client, errors.New("DOCKER_TLS_VERIFY set without DOCKER_CERT_PATH")
-		}
+var (
+	ErrNotRegularFile = errors.New("Not a regular file")
+	ErrFileTooLarge   = errors.New("File too large")
+)
 
-		cert := path.Join(certPath, "cert.pem")
-		key := path.Join(certPath, "key.pem")
-		ca := path.Join(certPath, "ca.pem")
+// NewDockerClient creates a client for the endpoint. TLS connections are
+// set up here since the client library requires a client certificate and
+// can't verify with only a CA certificate.
+func NewDockerClient(e DockerEndpoint) (*docker.Client, error) {
+	if !e.TLS {
+		return docker.NewClient(e.Host)
+	}
 
-		client, err = docker.NewTLSClient(host, cert, key, ca)
+	config := &tls.Config{InsecureSkipVerify: !e.TLSVerify}
+	if e.TLSVerify {
+		ca, err := ioutil.ReadFile(e.CACert)
 		if err != nil {
-			return client, err
+			return nil, err
 		}
-	} else {
-		client, err = docker.NewClient(host)
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No CA certificate found in %s", e.CACert)
+		}
+	}
+	if e.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(e.Cert, e.Key)
 		if err != nil {
-			return client, err
+			return nil, err
 		}
+		config.Certificates = []tls.Certificate{cert}
 	}
 
+	host := e.Host
+	if strings.HasPrefix(host, "tcp://") {
+		host = "https://" + strings.TrimPrefix(host, "tcp://")
+	}
+	client, err := docker.NewClient(host)
+	if err != nil {
+		return nil, err
+	}
+	client.TLSConfig = config
+	client.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
 	return client, nil
 }
 
-func Eval(d DockerService, command string, image string) (EvalResult, error) {
+// EvalOptions configures the container created by Eval
+type EvalOptions struct {
+	Cmd     []string        // defaults to running the command with /bin/bash -c
+	Config  *docker.Config  // Env, WorkingDir and User set by directives
+	Runtime RuntimeOptions  // mounts, network and resources of the container
+	Cancel  <-chan struct{} // stops the command when closed
+	Output  io.Writer       // receives the streamed output, defaults to stdout
+	Pool    *containerPool  // pre-created containers to take instead of creating one
+	Links   []string        // services to link as container:alias
+}
+
+// stopGrace is how long an interrupted command gets to exit before it is killed
+const stopGrace = 2
+
+// Eval runs command in a new container created from image
+func Eval(d DockerService, command string, image string, opts EvalOptions) (EvalResult, error) {
 	res := EvalResult{
 		Command: command,
 		Image:   image,
 		Deleted: false,
 	}
 
-	cwd, _ := os.Getwd()
+	setup := time.Now()
+	options := evalContainer(command, image, opts)
+	pooled, ok := pooledContainer(command, options)
+	if ok {
+		res.Id, ok = opts.Pool.take(pooled)
+	}
+	if !ok {
+		cont, err := d.CreateContainer(options)
+		if err != nil {
+			return res, err
+		}
+		res.Id = cont.ID
+	}
+
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	buf := NewBuffer(out)
+	attachOpts := docker.AttachToContainerOptions{
+		Container:    res.Id,
+		OutputStream: buf,
+		ErrorStream:  buf,
+		Logs:         true,
+		Stream:       true,
+		Stdin:        false,
+		Stdout:       true,
+		Stderr:       true,
+	}
+
+	errs := make(chan error, 1)
+	attach := func() {
+		go func() {
+			errs <- d.AttachToContainer(attachOpts)
+		}()
+	}
+	if ok {
+		// pooled containers read the command from stdin, which has to be
+		// attached before they start
+		attached := make(chan struct{})
+		attachOpts.InputStream = strings.NewReader(command)
+		attachOpts.Stdin = true
+		attachOpts.Success = attached
+		attach()
+		select {
+		case <-attached:
+			attached <- struct{}{}
+		case err := <-errs:
+			return res, err
+		}
+	}
+	res.Setup = time.Since(setup)
+
+	start := time.Now()
+	err := d.StartContainer(res.Id, &docker.HostConfig{})
+	if err != nil && ok {
+		// a pooled container that doesn't start is replaced by a new one
+		RemoveContainer(d, res.Id)
+		<-errs
+		ok = false
+		attachOpts.InputStream, attachOpts.Stdin, attachOpts.Success = nil, false, nil
+		var cont *docker.Container
+		if cont, err = d.CreateContainer(options); err != nil {
+			return res, err
+		}
+		res.Id = cont.ID
+		err = d.StartContainer(res.Id, &docker.HostConfig{})
+	}
+	if err != nil {
+		return res, err
+	}
+	if !ok {
+		// output written before the attach is replayed from the logs
+		attach()
+	}
+
+	res.Code, res.Interrupted, err = wait(d, res.Id, opts.Runtime.Timeout, opts.Cancel)
+	if err != nil {
+		return res, err
+	}
+	// the output is complete once the attach returns after the exit
+	<-errs
+	res.Log = buf
+	res.Duration = time.Since(start)
+
+	res.Changes, err = d.ContainerChanges(res.Id)
+	if err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// Copy streams the tar archive into dest inside a new container created
+// from image. The image needs sh and tar to unpack the archive.
+func Copy(d DockerService, archive io.Reader, dest string, image string) (EvalResult, error) {
+	res := EvalResult{
+		Image:   image,
+		Deleted: false,
+	}
 
 	options := docker.CreateContainerOptions{
 		Config: &docker.Config{
-			Image: image,
-			Cmd:   []string{"/bin/bash", "-c", command},
-		},
-		HostConfig: &docker.HostConfig{
-			Binds: []string{fmt.Sprintf("%s:/work", cwd)},
+			Image:       image,
+			Cmd:         []string{"/bin/sh", "-c", `mkdir -p "$1" && tar -xof - -C "$1"`, "sh", dest},
+			User:        "root",
+			AttachStdin: true,
+			OpenStdin:   true,
+			StdinOnce:   true,
 		},
 	}
 	cont, err := d.CreateContainer(options)
@@ -74,21 +222,32 @@ func Eval(d DockerService, command string, image string) (EvalResult, error) {
 	res.Id = cont.ID
 
 	buf := NewBuffer(os.Stdout)
+	attached := make(chan struct{})
 	attachOpts := docker.AttachToContainerOptions{
 		Container:    cont.ID,
+		InputStream:  archive,
 		OutputStream: buf,
 		ErrorStream:  buf,
-		Logs:         true,
 		Stream:       true,
-		Stdin:        false,
+		Stdin:        true,
 		Stdout:       true,
 		Stderr:       true,
+		Success:      attached,
 	}
 
+	errs := make(chan error, 1)
 	go func() {
-		d.AttachToContainer(attachOpts)
+		errs <- d.AttachToContainer(attachOpts)
 	}()
 
+	// stdin has to be attached before tar starts reading
+	select {
+	case <-attached:
+		attached <- struct{}{}
+	case err := <-errs:
+		return res, err
+	}
+
 	start := time.Now()
 	if err := d.StartContainer(cont.ID, &docker.HostConfig{}); err != nil {
 		return res, err
@@ -98,12 +257,10 @@ func Eval(d DockerService, command string, image string) (EvalResult, error) {
 	if err != nil {
 		return res, err
 	}
+	<-errs
 	res.Log = buf
 	res.Duration = time.Since(start)
 
-	if res.Code == 0 {
-	}
-
 	res.Changes, err = d.ContainerChanges(cont.ID)
 	if err != nil {
 		return res, err
@@ -111,14 +268,378 @@ func Eval(d DockerService, command string, image string) (EvalResult, error) {
 	return res, nil
 }
 
-func CommitContainer(d DockerService, id string) (string, error) {
-	if image, err := d.CommitContainer(docker.CommitContainerOptions{Container: id}); err != nil {
+// wait waits for the container to exit. When timeout passes or cancel is
+// closed first, the container is stopped and the reason is returned.
+func wait(d DockerService, id string, timeout time.Duration, cancel <-chan struct{}) (int, string, error) {
+	type exit struct {
+		code int
+		err  error
+	}
+	exited := make(chan exit, 1)
+	go func() {
+		code, err := d.WaitContainer(id)
+		exited <- exit{code, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var reason string
+	select {
+	case e := <-exited:
+		return e.code, "", e.err
+	case <-expired:
+		reason = "timeout"
+	case <-cancel:
+		reason = "interrupt"
+	}
+	if err := d.StopContainer(id, stopGrace); err != nil {
+		// the container exited meanwhile
+		if _, ok := err.(*docker.ContainerNotRunning); !ok {
+			return -1, reason, err
+		}
+	}
+	e := <-exited
+	return e.code, reason, e.err
+}
+
+// Interactive runs cmd in a new container created from image with a TTY
+// attached to in and out, until cmd exits. Terminal sizes received on resize
+// are propagated to the TTY.
+func Interactive(d DockerService, image string, opts EvalOptions, in io.Reader, out io.Writer, resize <-chan [2]int) (EvalResult, error) {
+	res := EvalResult{
+		Command: strings.Join(opts.Cmd, " "),
+		Image:   image,
+	}
+
+	config := runConfig(image, opts.Cmd, opts.Config)
+	config.Tty = true
+	config.AttachStdin = true
+	config.AttachStdout = true
+	config.AttachStderr = true
+	config.OpenStdin = true
+	config.StdinOnce = true
+	host := workHostConfig()
+	host.Links = opts.Links
+	opts.Runtime.apply(config, host)
+	cont, err := d.CreateContainer(docker.CreateContainerOptions{Config: config, HostConfig: host})
+	if err != nil {
+		return res, err
+	}
+	res.Id = cont.ID
+
+	// in is fed through a pipe that is closed when the container exits, so
+	// the attach doesn't keep reading from in after the session ended
+	stdin, feed := io.Pipe()
+	defer stdin.Close()
+	go func() {
+		io.Copy(feed, in)
+		feed.Close()
+	}()
+
+	attached := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.AttachToContainer(docker.AttachToContainerOptions{
+			Container:    cont.ID,
+			InputStream:  stdin,
+			OutputStream: out,
+			ErrorStream:  out,
+			Stream:       true,
+			Stdin:        true,
+			Stdout:       true,
+			Stderr:       true,
+			RawTerminal:  true,
+			Success:      attached,
+		})
+	}()
+	select {
+	case <-attached:
+		attached <- struct{}{}
+	case err := <-errs:
+		return res, err
+	}
+
+	start := time.Now()
+	if err := d.StartContainer(cont.ID, &docker.HostConfig{}); err != nil {
+		return res, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case size := <-resize:
+				d.ResizeContainerTTY(cont.ID, size[0], size[1])
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	res.Code, err = d.WaitContainer(cont.ID)
+	stdin.Close()
+	if err != nil {
+		return res, err
+	}
+	<-errs
+	res.Duration = time.Since(start)
+
+	res.Changes, err = d.ContainerChanges(cont.ID)
+	return res, err
+}
+
+// evalContainer returns the options of the container Eval runs command in
+func evalContainer(command string, image string, opts EvalOptions) docker.CreateContainerOptions {
+	cmd := opts.Cmd
+	if len(cmd) == 0 {
+		cmd = []string{"/bin/bash", "-c", command}
+	}
+
+	options := docker.CreateContainerOptions{
+		Config:     runConfig(image, cmd, opts.Config),
+		HostConfig: workHostConfig(),
+	}
+	options.HostConfig.Links = opts.Links
+	opts.Runtime.apply(options.Config, options.HostConfig)
+	return options
+}
+
+// runConfig returns the config for a container running cmd on image with
+// the Env, WorkingDir and User of config
+func runConfig(image string, cmd []string, config *docker.Config) *docker.Config {
+	if config == nil {
+		config = &docker.Config{}
+	}
+	run := &docker.Config{
+		Image:      image,
+		Cmd:        cmd,
+		Env:        config.Env,
+		WorkingDir: config.WorkingDir,
+		User:       config.User,
+	}
+	// a configured ENTRYPOINT would otherwise wrap the command
+	if len(config.Entrypoint) > 0 {
+		run.Entrypoint = cmd[:1]
+		run.Cmd = cmd[1:]
+	}
+	return run
+}
+
+// workHostConfig mounts the current directory at /work
+func workHostConfig() *docker.HostConfig {
+	cwd, _ := os.Getwd()
+	return &docker.HostConfig{
+		Binds: []string{fmt.Sprintf("%s:/work", cwd)},
+	}
+}
+
+// CommitContainer commits the container, applying config to the new image.
+// Without a Cmd in config the image keeps the Cmd of the image the container
+// was created from, rather than the command the container ran.
+func CommitContainer(d DockerService, id string, config *docker.Config) (string, error) {
+	if config == nil || len(config.Cmd) == 0 {
+		cont, err := d.InspectContainer(id)
+		if err != nil {
+			return "", err
+		}
+		image, err := d.InspectImage(cont.Image)
+		if err != nil {
+			return "", err
+		}
+		run := docker.Config{}
+		if config != nil {
+			run = *config
+		}
+		if image.Config != nil {
+			run.Cmd = image.Config.Cmd
+		}
+		config = &run
+	}
+	if image, err := d.CommitContainer(docker.CommitContainerOptions{Container: id, Run: config}); err != nil {
 		return "", err
 	} else {
 		return image.ID, nil
 	}
 }
 
+// CommitConfig commits a new image from image with config applied, without
+// running anything. Used to bake directives into an image like docker build.
+func CommitConfig(d DockerService, image string, config *docker.Config) (string, error) {
+	cont, err := createNop(d, image)
+	if err != nil {
+		return "", err
+	}
+	defer RemoveContainer(d, cont.ID)
+	return CommitContainer(d, cont.ID, config)
+}
+
+// Flatten exports the filesystem of image and imports it as a single layer
+// image. The export drops the image config, so config is committed on top.
+func Flatten(d DockerService, image string, config *docker.Config) (string, error) {
+	cont, err := createNop(d, image)
+	if err != nil {
+		return "", err
+	}
+	defer RemoveContainer(d, cont.ID)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(d.ExportContainer(docker.ExportContainerOptions{ID: cont.ID, OutputStream: pw}))
+	}()
+
+	var out bytes.Buffer
+	err = d.ImportImage(docker.ImportImageOptions{
+		Repository:   "cyclops-flattened",
+		Source:       "-",
+		InputStream:  pr,
+		OutputStream: &out,
+	})
+	pr.Close()
+	if err != nil {
+		return "", err
+	}
+	// the daemon reports the id of the imported image as the last status
+	lines := strings.Fields(out.String())
+	if len(lines) == 0 {
+		return "", errors.New("No image id returned by import")
+	}
+	return CommitConfig(d, lines[len(lines)-1], config)
+}
+
+// createNop creates a container from image that is never started
+func createNop(d DockerService, image string) (*docker.Container, error) {
+	return d.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image: image,
+			Cmd:   []string{"/bin/sh", "-c", "#(nop) cyclops"},
+		},
+	})
+}
+
+// TagImage tags image as name, which is in repo:tag format
+func TagImage(d DockerService, image string, name string) error {
+	repo, tag := repositoryTag(name)
+	return d.TagImage(image, docker.TagImageOptions{Repo: repo, Tag: tag, Force: true})
+}
+
+// repositoryTag splits an image name into repository and tag, which
+// defaults to latest like docker does
+func repositoryTag(name string) (string, string) {
+	repo, tag := docker.ParseRepositoryTag(name)
+	if tag == "" {
+		tag = "latest"
+	}
+	return repo, tag
+}
+
+// ExportImage writes image to w as a tarball that can be loaded with docker load
+func ExportImage(d DockerService, image string, w io.Writer) error {
+	return d.ExportImage(docker.ExportImageOptions{Name: image, OutputStream: w})
+}
+
+// isImageDir reports whether path is a directory in image
+func isImageDir(d DockerService, image string, path string) (bool, error) {
+	out, _, err := Output(d, image, []string{"/bin/sh", "-c", `test -d "$1" && echo dir`, "sh", path})
+	return out == "dir\n", err
+}
+
+// Output runs cmd in a new container created from image and returns what it
+// wrote to stdout. The container is removed afterwards.
+func Output(d DockerService, image string, cmd []string) (string, int, error) {
+	cont, err := d.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: image, Cmd: cmd},
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	defer RemoveContainer(d, cont.ID)
+
+	var out bytes.Buffer
+	attached := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.AttachToContainer(docker.AttachToContainerOptions{
+			Container:    cont.ID,
+			OutputStream: &out,
+			ErrorStream:  ioutil.Discard,
+			Stream:       true,
+			Stdout:       true,
+			Stderr:       true,
+			Success:      attached,
+		})
+	}()
+	select {
+	case <-attached:
+		attached <- struct{}{}
+	case err := <-errs:
+		return "", 0, err
+	}
+
+	if err := d.StartContainer(cont.ID, &docker.HostConfig{}); err != nil {
+		return "", 0, err
+	}
+	code, err := d.WaitContainer(cont.ID)
+	if err != nil {
+		return "", code, err
+	}
+	// the stream ends when the container exits
+	if err := <-errs; err != nil {
+		return "", code, err
+	}
+	return out.String(), code, nil
+}
+
+// ReadFile returns the contents of the regular file at path in the container,
+// or false if it doesn't exist. Files larger than limit are not read.
+func ReadFile(d DockerService, id string, path string, limit int64) ([]byte, bool, error) {
+	var buf bytes.Buffer
+	err := d.CopyFromContainer(docker.CopyFromContainerOptions{
+		Container:    id,
+		Resource:     path,
+		OutputStream: &buf,
+	})
+	if _, ok := err.(*docker.NoSuchContainer); ok {
+		// the client reports a missing path as a missing container
+		if _, err := d.InspectContainer(id); err != nil {
+			return nil, false, err
+		}
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	archive := tar.NewReader(&buf)
+	header, err := archive.Next()
+	if err != nil {
+		return nil, false, err
+	}
+	if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+		return nil, true, ErrNotRegularFile
+	}
+	if header.Size > limit {
+		return nil, true, ErrFileTooLarge
+	}
+	content, err := ioutil.ReadAll(archive)
+	return content, true, err
+}
+
+// ReadImageFile is ReadFile for a file in image, read through a container
+// that is never started
+func ReadImageFile(d DockerService, image string, path string, limit int64) ([]byte, bool, error) {
+	cont, err := createNop(d, image)
+	if err != nil {
+		return nil, false, err
+	}
+	defer RemoveContainer(d, cont.ID)
+	return ReadFile(d, cont.ID, path, limit)
+}
+
 func RemoveContainer(d DockerService, id string) error {
 	return d.RemoveContainer(docker.RemoveContainerOptions{ID: id})
 }
@@ -1,64 +1,118 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestNewDockerClientNegative(t *testing.T) {
-	assert := assert.New(t)
-
-	_, err := NewDockerClient("", "", "")
-	assert.EqualError(err, "DOCKER_HOST must be set")
-}
-
 func TestNewDockerClientSocket(t *testing.T) {
 	assert := assert.New(t)
 
-	_, err := NewDockerClient("unix://var/run/docker.sock", "", "")
+	_, err := NewDockerClient(DockerEndpoint{Host: "unix:///var/run/docker.sock"})
 	assert.NoError(err)
 }
 
 func TestNewDockerClientTCPSecure(t *testing.T) {
 	assert := assert.New(t)
 
-	_, err := NewDockerClient("tcp://192.168.254.254:2376/", "yes", "")
-	assert.EqualError(err, "DOCKER_TLS_VERIFY set without DOCKER_CERT_PATH")
+	_, err := NewDockerClient(DockerEndpoint{Host: "tcp://192.168.254.254:2376/", TLS: true, TLSVerify: true, CACert: "fixtures/certs/missing.pem"})
+	assert.Error(err)
 
-	_, err = NewDockerClient("tcp://192.168.254.254:2376/", "1", "")
-	assert.EqualError(err, "DOCKER_TLS_VERIFY set without DOCKER_CERT_PATH")
+	_, err = NewDockerClient(DockerEndpoint{Host: "tcp://192.168.254.254:2376/", TLS: true, TLSVerify: true, CACert: "fixtures/certs/ca.pem"})
+	assert.EqualError(err, "No CA certificate found in fixtures/certs/ca.pem")
 
-	_, err = NewDockerClient("tcp://192.168.254.254:2376/", "1", "fixtures/certs/")
+	_, err = NewDockerClient(DockerEndpoint{Host: "tcp://192.168.254.254:2376/", TLS: true, Cert: "fixtures/certs/cert.pem", Key: "fixtures/certs/key.pem"})
 	assert.Error(err)
 }
 
 func TestNewDockerClientTCPInsecure(t *testing.T) {
 	assert := assert.New(t)
 
-	_, err := NewDockerClient("tcp://192.168.254.254:2376/", "no", "")
-	assert.NoError(err)
-
-	_, err = NewDockerClient("tcp://192.168.254.254:2376/", "0", "")
+	_, err := NewDockerClient(DockerEndpoint{Host: "tcp://192.168.254.254:2376/"})
 	assert.NoError(err)
 
-	_, err = NewDockerClient("tcp://192.168.254.254:2376/", "", "")
+	client, err := NewDockerClient(DockerEndpoint{Host: "tcp://192.168.254.254:2376/", TLS: true})
 	assert.NoError(err)
+	assert.True(client.TLSConfig.InsecureSkipVerify)
 }
 
 func TestEval(t *testing.T) {
 	assert := assert.New(t)
 
-	res, err := Eval(NewMockDockerClient(), "date", "ubuntu:trusty")
+	res, err := Eval(NewMockDockerClient(), "date", "ubuntu:trusty", EvalOptions{})
 	assert.NoError(err)
 	assert.Equal("date", res.Command)
 	assert.Equal("ubuntu:trusty", res.Image)
 }
 
+func TestEvalTimeout(t *testing.T) {
+	assert := assert.New(t)
+	md := NewMockDockerClient()
+	md.Hang = true
+
+	opts := EvalOptions{Runtime: RuntimeOptions{Timeout: 10 * time.Millisecond}}
+	res, err := Eval(md, "sleep infinity", "ubuntu:trusty", opts)
+	assert.NoError(err)
+	assert.Equal(137, res.Code)
+	assert.Equal("timeout", res.Interrupted)
+	assert.NotNil(res.Log)
+}
+
+func TestEvalCancel(t *testing.T) {
+	assert := assert.New(t)
+	md := NewMockDockerClient()
+	md.Hang = true
+
+	cancel := make(chan struct{})
+	close(cancel)
+	res, err := Eval(md, "sleep infinity", "ubuntu:trusty", EvalOptions{Cancel: cancel})
+	assert.NoError(err)
+	assert.Equal(137, res.Code)
+	assert.Equal("interrupt", res.Interrupted)
+}
+
+func TestEvalCancelExited(t *testing.T) {
+	assert := assert.New(t)
+	md := NewMockDockerClient()
+	md.Hang = true
+	md.Exited["c1"] = true
+
+	// the container exits before it is stopped
+	cancel := make(chan struct{})
+	close(cancel)
+	res, err := Eval(md, "sleep 1", "ubuntu:trusty", EvalOptions{Cancel: cancel})
+	assert.NoError(err)
+	assert.Equal(137, res.Code)
+	assert.Equal("interrupt", res.Interrupted)
+	assert.Empty(md.Stopped)
+}
+
+func TestInteractiveStdin(t *testing.T) {
+	assert := assert.New(t)
+	md := NewMockDockerClient()
+	md.Outputs["ubuntu:trusty"] = "root@c1:/# exit\n"
+
+	// the terminal is still open when the shell exits
+	in, _ := io.Pipe()
+	var out bytes.Buffer
+	res, err := Interactive(md, "ubuntu:trusty", EvalOptions{Cmd: []string{"/bin/bash"}}, in, &out, make(chan [2]int))
+	assert.NoError(err)
+	assert.Equal("c1", res.Id)
+	assert.Equal("root@c1:/# exit\n", out.String())
+}
+
 func TestVerifyImage(t *testing.T) {
 	assert := assert.New(t)
 
@@ -72,20 +126,39 @@ func TestVerifyImage(t *testing.T) {
 	assert.Error(err)
 }
 
-//Mock Docker Client for use by Servers and Workspaces for testing
+// Mock Docker Client for use by Servers and Workspaces for testing
 type MockDockerClient struct {
-	FailAttach   bool
-	FailChanges  bool
-	FailCommit   bool
-	FailCreate   bool
-	FailRemove   bool
-	FailStart    bool
-	FailWait     bool
-	FailInspect  bool
-	PleaseReturn int
-	lastId       int
-	Containers   []*docker.Container
-	Images       []*docker.Image
+	FailAttach    bool
+	FailChanges   bool
+	FailCommit    bool
+	FailCreate    bool
+	FailRemove    bool
+	FailStart     bool
+	FailWait      bool
+	FailInspect   bool
+	FailTag       bool
+	FailExport    bool
+	FailImport    bool
+	FailPull      bool
+	PleaseReturn  int
+	MissingImages map[string]bool
+	Files         map[string]map[string]string //container ID or image to file contents by path
+	Outputs       map[string]string            //image to the output of containers created from it
+	Changes       []docker.Change              //changes of every container
+	Configs       map[string]*docker.Config    //image to its config
+	Committed     []docker.CommitContainerOptions
+	Created       []docker.CreateContainerOptions
+	Pulled        []string        //images pulled, as repository:tag
+	Hang          bool            //WaitContainer blocks until the container is stopped
+	Stopped       []string        //IDs of stopped containers
+	Exited        map[string]bool //IDs of containers that exited on their own
+	Unstartable   map[string]bool //IDs of containers that fail to start
+	mu            sync.Mutex
+	stopped       chan struct{}
+	lastId        int
+	Containers    []*docker.Container
+	Images        []*docker.Image
+	Tags          map[string]string
 }
 
 func NewMockDockerClient() *MockDockerClient {
@@ -98,18 +171,49 @@ func NewMockDockerClient() *MockDockerClient {
 		FailStart:    false,
 		FailWait:     false,
 		FailInspect:  false,
+		FailTag:      false,
+		FailExport:   false,
+		FailImport:   false,
 		PleaseReturn: 0,
 		lastId:       0,
+		Tags:         map[string]string{},
+		Files:        map[string]map[string]string{},
+		Outputs:      map[string]string{},
+		Exited:       map[string]bool{},
+		Unstartable:  map[string]bool{},
+		Configs:      map[string]*docker.Config{},
+		stopped:      make(chan struct{}),
 	}
 }
 
-func (m *MockDockerClient) AttachToContainer(docker.AttachToContainerOptions) error {
+func (m *MockDockerClient) AttachToContainer(opts docker.AttachToContainerOptions) error {
 	if m.FailAttach {
 		return errors.New("MOCK: Failed to attach")
 	}
+	if opts.Success != nil {
+		opts.Success <- struct{}{}
+		<-opts.Success
+	}
+	if opts.InputStream != nil {
+		io.Copy(ioutil.Discard, opts.InputStream)
+	}
+	if image, ok := m.containerImage(opts.Container); ok && opts.OutputStream != nil {
+		io.WriteString(opts.OutputStream, m.Outputs[image])
+	}
 	return nil
 }
 
+func (m *MockDockerClient) containerImage(id string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.Containers {
+		if c.ID == id {
+			return c.Image, true
+		}
+	}
+	return "", false
+}
+
 func (m *MockDockerClient) CommitContainer(opts docker.CommitContainerOptions) (*docker.Image, error) {
 	if m.FailCommit {
 		return &docker.Image{}, errors.New("MOCK: Failed to commit")
@@ -118,6 +222,7 @@ func (m *MockDockerClient) CommitContainer(opts docker.CommitContainerOptions) (
 		ID: strings.Replace(opts.Container, "c", "i", 1),
 	}
 	m.Images = append(m.Images, image)
+	m.Committed = append(m.Committed, opts)
 
 	return image, nil
 }
@@ -126,25 +231,49 @@ func (m *MockDockerClient) ContainerChanges(string) ([]docker.Change, error) {
 	if m.FailChanges {
 		return []docker.Change{}, errors.New("MOCK: Failed to determine changes")
 	}
+	if m.Changes != nil {
+		return m.Changes, nil
+	}
 	return []docker.Change{}, nil
 }
 
-func (m *MockDockerClient) CreateContainer(docker.CreateContainerOptions) (*docker.Container, error) {
+func (m *MockDockerClient) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
 	if m.FailCreate {
 		return &docker.Container{}, errors.New("MOCK: Failed to create container")
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Created = append(m.Created, opts)
 	m.lastId++
 	cont := &docker.Container{
 		ID: fmt.Sprintf("c%v", m.lastId),
 	}
+	if opts.Config != nil {
+		cont.Image = opts.Config.Image
+	}
 	m.Containers = append(m.Containers, cont)
 	return cont, nil
 }
 
+func (m *MockDockerClient) InspectContainer(id string) (*docker.Container, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.Containers {
+		if c.ID == id {
+			cont := *c
+			cont.State.Running = !m.Exited[id]
+			return &cont, nil
+		}
+	}
+	return nil, &docker.NoSuchContainer{ID: id}
+}
+
 func (m *MockDockerClient) RemoveContainer(opts docker.RemoveContainerOptions) error {
 	if m.FailRemove {
 		return errors.New("MOCK: Failed to remove container")
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	var newContainers []*docker.Container
 	if len(m.Containers) == 1 {
 		m.Containers = []*docker.Container{}
@@ -163,23 +292,156 @@ func (m *MockDockerClient) RemoveContainer(opts docker.RemoveContainerOptions) e
 	return nil
 }
 
-func (m *MockDockerClient) StartContainer(string, *docker.HostConfig) error {
-	if m.FailStart {
+func (m *MockDockerClient) StartContainer(id string, host *docker.HostConfig) error {
+	if m.FailStart || m.Unstartable[id] {
 		return errors.New("MOCK: Failed to start container")
 	}
 	return nil
 }
 
 func (m *MockDockerClient) WaitContainer(string) (int, error) {
+	if m.Hang {
+		<-m.stopped
+		return 137, nil
+	}
 	if m.FailWait {
 		return m.PleaseReturn, errors.New("MOCK: Failed to wait on container")
 	}
 	return m.PleaseReturn, nil
 }
 
-func (m *MockDockerClient) InspectImage(string) (*docker.Image, error) {
+func (m *MockDockerClient) InspectImage(name string) (*docker.Image, error) {
 	if m.FailInspect {
-		return &docker.Image{}, errors.New("MOCK: Failed to find image")
+		return &docker.Image{}, errors.New("MOCK: Failed to inspect image")
+	}
+	if m.MissingImages[name] {
+		return &docker.Image{}, docker.ErrNoSuchImage
+	}
+	return &docker.Image{Config: m.Configs[name]}, nil
+}
+
+func (m *MockDockerClient) TagImage(name string, opts docker.TagImageOptions) error {
+	if m.FailTag {
+		return errors.New("MOCK: Failed to tag image")
+	}
+	m.Tags[opts.Repo+":"+opts.Tag] = name
+	return nil
+}
+
+func (m *MockDockerClient) ExportImage(opts docker.ExportImageOptions) error {
+	if m.FailExport {
+		return errors.New("MOCK: Failed to export image")
+	}
+	_, err := opts.OutputStream.Write([]byte(opts.Name))
+	return err
+}
+
+func (m *MockDockerClient) ExportContainer(opts docker.ExportContainerOptions) error {
+	if m.FailExport {
+		return errors.New("MOCK: Failed to export container")
+	}
+	_, err := opts.OutputStream.Write([]byte(opts.ID))
+	return err
+}
+
+func (m *MockDockerClient) ImportImage(opts docker.ImportImageOptions) error {
+	if m.FailImport {
+		return errors.New("MOCK: Failed to import image")
+	}
+	io.Copy(ioutil.Discard, opts.InputStream)
+	image := &docker.Image{
+		ID: fmt.Sprintf("import%v", len(m.Images)+1),
+	}
+	m.Images = append(m.Images, image)
+	fmt.Fprintln(opts.OutputStream, image.ID)
+	return nil
+}
+
+func (m *MockDockerClient) StopContainer(id string, timeout uint) error {
+	select {
+	case <-m.stopped:
+	default:
+		close(m.stopped)
+	}
+	if m.Exited[id] {
+		return &docker.ContainerNotRunning{ID: id}
+	}
+	m.Stopped = append(m.Stopped, id)
+	return nil
+}
+
+func (m *MockDockerClient) Logs(opts docker.LogsOptions) error {
+	if image, ok := m.containerImage(opts.Container); ok {
+		io.WriteString(opts.OutputStream, m.Outputs[image])
+		return nil
+	}
+	return &docker.NoSuchContainer{ID: opts.Container}
+}
+
+func (m *MockDockerClient) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	m.Pulled = append(m.Pulled, opts.Repository+":"+opts.Tag)
+	if m.FailPull {
+		fmt.Fprintln(opts.OutputStream, `{"error":"MOCK: Failed to pull image"}`)
+		return nil
 	}
-	return &docker.Image{}, nil
+	fmt.Fprintf(opts.OutputStream, `{"status":"Pulling from %s"}`+"\n", opts.Repository)
+	fmt.Fprintln(opts.OutputStream, `{"status":"Downloading","progressDetail":{"current":1,"total":2},"id":"abc123"}`)
+	fmt.Fprintln(opts.OutputStream, `{"status":"Pull complete","progressDetail":{},"id":"abc123"}`)
+	delete(m.MissingImages, opts.Repository+":"+opts.Tag)
+	return nil
+}
+
+func (m *MockDockerClient) ResizeContainerTTY(id string, height int, width int) error {
+	return nil
+}
+
+func (m *MockDockerClient) CopyFromContainer(opts docker.CopyFromContainerOptions) error {
+	image, found := m.containerImage(opts.Container)
+	if !found {
+		return &docker.NoSuchContainer{ID: opts.Container}
+	}
+	content, ok := m.Files[opts.Container][opts.Resource]
+	if !ok {
+		content, ok = m.Files[image][opts.Resource]
+	}
+	if !ok {
+		// like the client, which maps every 404 to NoSuchContainer
+		return &docker.NoSuchContainer{ID: opts.Container}
+	}
+	archive := tar.NewWriter(opts.OutputStream)
+	archive.WriteHeader(&tar.Header{Name: path.Base(opts.Resource), Mode: 0644, Size: int64(len(content))})
+	archive.Write([]byte(content))
+	return archive.Close()
+}
+
+func TestReadFile(t *testing.T) {
+	assert := assert.New(t)
+	md := NewMockDockerClient()
+	md.Files["ubuntu:trusty"] = map[string]string{"/etc/hostname": "cyclops\n"}
+
+	content, ok, err := ReadImageFile(md, "ubuntu:trusty", "/etc/hostname", 1024)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal("cyclops\n", string(content))
+	assert.Len(md.Containers, 0)
+
+	_, ok, err = ReadImageFile(md, "ubuntu:trusty", "/etc/motd", 1024)
+	assert.NoError(err)
+	assert.False(ok)
+
+	_, ok, err = ReadImageFile(md, "ubuntu:trusty", "/etc/hostname", 4)
+	assert.Equal(ErrFileTooLarge, err)
+	assert.True(ok)
+}
+
+func TestOutput(t *testing.T) {
+	assert := assert.New(t)
+	md := NewMockDockerClient()
+	md.Outputs["fedora"] = "bash 4.3.42-1.fc23\n"
+
+	out, code, err := Output(md, "fedora", []string{"rpm", "-qa"})
+	assert.NoError(err)
+	assert.Equal(0, code)
+	assert.Equal("bash 4.3.42-1.fc23\n", out)
+	assert.Len(md.Containers, 0)
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Instruction is a single parsed Dockerfile line
+type Instruction struct {
+	Name string // upper case instruction, e.g. RUN
+	Args string
+}
+
+// parseDockerfile reads Dockerfile instructions, joining line continuations
+// and skipping comments and blank lines
+func parseDockerfile(r io.Reader) ([]Instruction, error) {
+	instructions := []Instruction{}
+	scanner := bufio.NewScanner(r)
+	var current []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(current) == 0 && (line == "" || strings.HasPrefix(line, "#")) {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			current = append(current, strings.TrimSpace(strings.TrimSuffix(line, "\\")))
+			continue
+		}
+		current = append(current, line)
+		instructions = append(instructions, newInstruction(strings.Join(current, " ")))
+		current = nil
+	}
+	if len(current) > 0 {
+		instructions = append(instructions, newInstruction(strings.Join(current, " ")))
+	}
+	return instructions, scanner.Err()
+}
+
+func newInstruction(line string) Instruction {
+	parts := strings.SplitN(line, " ", 2)
+	inst := Instruction{Name: strings.ToUpper(parts[0])}
+	if len(parts) > 1 {
+		inst.Args = strings.TrimSpace(parts[1])
+	}
+	return inst
+}
+
+// readDockerfile parses the Dockerfile at path. COPY sources are resolved
+// against the directory of the Dockerfile, which is its build context.
+func readDockerfile(path string) ([]Instruction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	instructions, err := parseDockerfile(f)
+	if err != nil {
+		return nil, err
+	}
+	context := filepath.Dir(path)
+	for i, inst := range instructions {
+		if inst.Name != "COPY" {
+			continue
+		}
+		parts := strings.Fields(inst.Args)
+		if len(parts) == 2 && !filepath.IsAbs(parts[0]) {
+			instructions[i].Args = filepath.Join(context, parts[0]) + " " + parts[1]
+		}
+	}
+	return instructions, nil
+}
+
+// Command returns the cyclops command and arguments that replay the
+// instruction, or false if the instruction is not supported
+func (i Instruction) Command() (string, string, bool) {
+	switch i.Name {
+	case "FROM":
+		return "from", i.Args, true
+	case "RUN":
+		// exec form is run through the shell like the shell form
+		var args []string
+		if err := json.Unmarshal([]byte(i.Args), &args); err == nil {
+			return "run", shellJoin(args), true
+		}
+		return "run", i.Args, true
+	case "COPY":
+		return "copy", i.Args, true
+	}
+	for command, instruction := range directives {
+		if instruction == i.Name {
+			return command, i.Args, true
+		}
+	}
+	return "", "", false
+}
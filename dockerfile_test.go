@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	assert := assert.New(t)
+
+	dockerfile := `# base image
+FROM ubuntu:trusty
+
+run apt-get update && \
+    apt-get install -y nginx
+ENV  FOO=bar
+WORKDIR /app
+`
+	instructions, err := parseDockerfile(strings.NewReader(dockerfile))
+	assert.NoError(err)
+	expected := []Instruction{
+		{"FROM", "ubuntu:trusty"},
+		{"RUN", "apt-get update && apt-get install -y nginx"},
+		{"ENV", "FOO=bar"},
+		{"WORKDIR", "/app"},
+	}
+	assert.Equal(expected, instructions)
+}
+
+func TestInstructionCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		Inst    Instruction
+		Command string
+		Args    string
+		Ok      bool
+	}{
+		{Instruction{"FROM", "ubuntu:trusty"}, "from", "ubuntu:trusty", true},
+		{Instruction{"RUN", "apt-get update"}, "run", "apt-get update", true},
+		{Instruction{"RUN", `["apt-get", "update"]`}, "run", "apt-get update", true},
+		{Instruction{"RUN", `["echo", "it's done"]`}, "run", `echo 'it'\''s done'`, true},
+		{Instruction{"ENV", "FOO=bar"}, "env", "FOO=bar", true},
+		{Instruction{"WORKDIR", "/app"}, "workdir", "/app", true},
+		{Instruction{"COPY", "nginx.conf /etc/nginx/"}, "copy", "nginx.conf /etc/nginx/", true},
+		{Instruction{"MAINTAINER", "cyclops"}, "", "", false},
+	}
+	for _, c := range cases {
+		command, args, ok := c.Inst.Command()
+		assert.Equal(c.Command, command, "Command should be equal for %s", c.Inst.Name)
+		assert.Equal(c.Args, args, "Args should be equal for %s", c.Inst.Name)
+		assert.Equal(c.Ok, ok, "Ok should be equal for %s", c.Inst.Name)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultDockerHost is used when no host is configured, like the docker cli
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// DockerEndpoint is the daemon to connect to and how to secure the
+// connection
+type DockerEndpoint struct {
+	Host      string
+	Source    string //where Host came from: --host, DOCKER_HOST, a context or default
+	TLS       bool
+	TLSVerify bool   //verify the daemon certificate against CACert
+	CACert    string //CA certificate, required to verify
+	Cert      string //client certificate, optional
+	Key       string
+}
+
+// String describes the endpoint for display
+func (e DockerEndpoint) String() string {
+	security := ""
+	switch {
+	case e.TLSVerify && e.Cert == "":
+		security = ", TLS verified without client certificate"
+	case e.TLSVerify:
+		security = ", TLS verified"
+	case e.TLS:
+		security = ", TLS without verification"
+	}
+	return fmt.Sprintf("%s (%s%s)", e.Host, e.Source, security)
+}
+
+// endpointOptions are the connection flags given on the command line,
+// mirroring the docker cli. Empty values were not given.
+type endpointOptions struct {
+	Host      string
+	TLS       bool
+	TLSVerify bool
+	CACert    string
+	Cert      string
+	Key       string
+}
+
+// dockerConfig is the part of the docker cli config.json used by cyclops
+type dockerConfig struct {
+	CurrentContext string `json:"currentContext"`
+	Auths          map[string]struct {
+		Auth  string `json:"auth"` //base64 of user:password
+		Email string `json:"email"`
+	} `json:"auths"`
+}
+
+// dockerConfigDir returns $DOCKER_CONFIG, defaulting to ~/.docker
+func dockerConfigDir(getenv func(string) string) string {
+	if dir := getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home := getenv("HOME")
+	if home == "" {
+		home = getenv("USERPROFILE")
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// readDockerConfig reads config.json in dir; a missing file is empty
+func readDockerConfig(dir string) (dockerConfig, error) {
+	var config dockerConfig
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %v", filepath.Join(dir, "config.json"), err)
+	}
+	return config, nil
+}
+
+// discoverEndpoint picks the daemon the way the docker cli does: the --host
+// flag, then DOCKER_HOST, then the current context of the docker config and
+// finally the local unix socket. TLS is enabled by --tls, --tlsverify,
+// DOCKER_TLS_VERIFY or by giving certificates; the certificates default to
+// ca.pem, cert.pem and key.pem in DOCKER_CERT_PATH or the config directory.
+// Verification needs a CA certificate, the client certificate is optional.
+func discoverEndpoint(opts endpointOptions, getenv func(string) string) (DockerEndpoint, error) {
+	configDir := dockerConfigDir(getenv)
+	certPath := getenv("DOCKER_CERT_PATH")
+	if certPath == "" {
+		certPath = configDir
+	}
+	e := DockerEndpoint{
+		TLS:       opts.TLS || opts.CACert != "" || opts.Cert != "" || opts.Key != "",
+		TLSVerify: opts.TLSVerify || isTrue(getenv("DOCKER_TLS_VERIFY")),
+	}
+
+	switch {
+	case opts.Host != "":
+		e.Host, e.Source = opts.Host, "--host"
+	case getenv("DOCKER_HOST") != "":
+		e.Host, e.Source = getenv("DOCKER_HOST"), "DOCKER_HOST"
+	default:
+		name := getenv("DOCKER_CONTEXT")
+		if name == "" {
+			config, err := readDockerConfig(configDir)
+			if err != nil {
+				return e, err
+			}
+			name = config.CurrentContext
+		}
+		if name == "" || name == "default" {
+			e.Host, e.Source = defaultDockerHost, "default"
+			break
+		}
+		host, skipVerify, tlsDir, err := readDockerContext(configDir, name)
+		if err != nil {
+			return e, err
+		}
+		e.Host, e.Source = host, "context "+name
+		if tlsDir != "" {
+			certPath = tlsDir
+			e.TLS = true
+			e.TLSVerify = e.TLSVerify || !skipVerify
+		}
+	}
+
+	e.TLS = e.TLS || e.TLSVerify
+	if !e.TLS || strings.HasPrefix(e.Host, "unix://") {
+		e.TLS, e.TLSVerify = false, false
+		return e, nil
+	}
+
+	e.CACert = certFile(opts.CACert, certPath, "ca.pem")
+	e.Cert = certFile(opts.Cert, certPath, "cert.pem")
+	e.Key = certFile(opts.Key, certPath, "key.pem")
+	if e.TLSVerify && e.CACert == "" {
+		return e, fmt.Errorf("TLS verification needs a CA certificate, none found at %s", filepath.Join(certPath, "ca.pem"))
+	}
+	if (e.Cert == "") != (e.Key == "") {
+		return e, fmt.Errorf("TLS client certificate and key must be given together")
+	}
+	if !e.TLSVerify {
+		e.CACert = ""
+	}
+	return e, nil
+}
+
+// certFile returns the given file, or name in dir when it exists
+func certFile(given string, dir string, name string) string {
+	if given != "" {
+		return given
+	}
+	file := filepath.Join(dir, name)
+	if _, err := os.Stat(file); err != nil {
+		return ""
+	}
+	return file
+}
+
+// readDockerContext returns the docker host of a docker cli context and the
+// directory of its TLS material, if it has any
+func readDockerContext(configDir string, name string) (string, bool, string, error) {
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	data, err := ioutil.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		return "", false, "", fmt.Errorf("docker context %s not found", name)
+	}
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string
+			SkipTLSVerify bool
+		}
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", false, "", err
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return "", false, "", fmt.Errorf("docker context %s has no docker endpoint", name)
+	}
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err != nil {
+		tlsDir = ""
+	}
+	return endpoint.Host, endpoint.SkipTLSVerify, tlsDir, nil
+}
+
+// isTrue interprets environment variables like DOCKER_TLS_VERIFY
+func isTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "yes", "true":
+		return true
+	}
+	return false
+}
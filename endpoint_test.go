@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func TestDiscoverEndpointHost(t *testing.T) {
+	assert := assert.New(t)
+
+	e, err := discoverEndpoint(endpointOptions{}, env(map[string]string{"HOME": "/nonexistent"}))
+	assert.NoError(err)
+	assert.Equal(defaultDockerHost, e.Host)
+	assert.Equal("default", e.Source)
+	assert.False(e.TLS)
+
+	e, err = discoverEndpoint(endpointOptions{}, env(map[string]string{"DOCKER_HOST": "tcp://10.0.0.1:2375"}))
+	assert.NoError(err)
+	assert.Equal("tcp://10.0.0.1:2375", e.Host)
+	assert.Equal("DOCKER_HOST", e.Source)
+
+	e, err = discoverEndpoint(endpointOptions{Host: "tcp://10.0.0.2:2375"}, env(map[string]string{"DOCKER_HOST": "tcp://10.0.0.1:2375"}))
+	assert.NoError(err)
+	assert.Equal("tcp://10.0.0.2:2375", e.Host)
+	assert.Equal("tcp://10.0.0.2:2375 (--host)", e.String())
+}
+
+func TestDiscoverEndpointTLS(t *testing.T) {
+	assert := assert.New(t)
+	vars := map[string]string{
+		"DOCKER_HOST":       "tcp://10.0.0.1:2376",
+		"DOCKER_TLS_VERIFY": "1",
+		"DOCKER_CERT_PATH":  "fixtures/certs",
+	}
+
+	e, err := discoverEndpoint(endpointOptions{}, env(vars))
+	assert.NoError(err)
+	assert.True(e.TLSVerify)
+	assert.Equal(filepath.Join("fixtures/certs", "ca.pem"), e.CACert)
+	assert.Equal(filepath.Join("fixtures/certs", "cert.pem"), e.Cert)
+	assert.Equal("tcp://10.0.0.1:2376 (DOCKER_HOST, TLS verified)", e.String())
+
+	// CA only
+	e, err = discoverEndpoint(endpointOptions{TLSVerify: true, CACert: "fixtures/certs/ca.pem"}, env(map[string]string{
+		"DOCKER_HOST": "tcp://10.0.0.1:2376",
+		"HOME":        "/nonexistent",
+	}))
+	assert.NoError(err)
+	assert.True(e.TLSVerify)
+	assert.Equal("", e.Cert)
+	assert.Equal("tcp://10.0.0.1:2376 (DOCKER_HOST, TLS verified without client certificate)", e.String())
+
+	_, err = discoverEndpoint(endpointOptions{TLSVerify: true}, env(map[string]string{
+		"DOCKER_HOST": "tcp://10.0.0.1:2376",
+		"HOME":        "/nonexistent",
+	}))
+	assert.Error(err)
+
+	// without verification
+	vars["DOCKER_TLS_VERIFY"] = "0"
+	e, err = discoverEndpoint(endpointOptions{TLS: true}, env(vars))
+	assert.NoError(err)
+	assert.True(e.TLS)
+	assert.False(e.TLSVerify)
+	assert.Equal("", e.CACert)
+	assert.Equal(filepath.Join("fixtures/certs", "key.pem"), e.Key)
+
+	_, err = discoverEndpoint(endpointOptions{Cert: "fixtures/certs/cert.pem"}, env(map[string]string{
+		"DOCKER_HOST": "tcp://10.0.0.1:2376",
+		"HOME":        "/nonexistent",
+	}))
+	assert.Error(err)
+
+	// TLS doesn't apply to the local socket
+	e, err = discoverEndpoint(endpointOptions{TLSVerify: true}, env(map[string]string{"HOME": "/nonexistent"}))
+	assert.NoError(err)
+	assert.False(e.TLS)
+}
+
+func TestDiscoverEndpointContext(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "cyclops")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	sum := sha256.Sum256([]byte("remote"))
+	id := hex.EncodeToString(sum[:])
+	meta := filepath.Join(dir, "contexts", "meta", id)
+	assert.NoError(os.MkdirAll(meta, 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(meta, "meta.json"),
+		[]byte(`{"Name":"remote","Endpoints":{"docker":{"Host":"tcp://10.0.0.3:2376","SkipTLSVerify":true}}}`), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext":"remote"}`), 0644))
+
+	e, err := discoverEndpoint(endpointOptions{}, env(map[string]string{"DOCKER_CONFIG": dir}))
+	assert.NoError(err)
+	assert.Equal("tcp://10.0.0.3:2376", e.Host)
+	assert.Equal("context remote", e.Source)
+	assert.False(e.TLS)
+
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	assert.NoError(os.MkdirAll(tlsDir, 0755))
+	e, err = discoverEndpoint(endpointOptions{}, env(map[string]string{"DOCKER_CONFIG": dir}))
+	assert.NoError(err)
+	assert.True(e.TLS)
+	assert.False(e.TLSVerify)
+
+	_, err = discoverEndpoint(endpointOptions{}, env(map[string]string{"DOCKER_CONFIG": dir, "DOCKER_CONTEXT": "missing"}))
+	assert.EqualError(err, "docker context missing not found")
+
+	e, err = discoverEndpoint(endpointOptions{}, env(map[string]string{"DOCKER_CONFIG": dir, "DOCKER_CONTEXT": "default"}))
+	assert.NoError(err)
+	assert.Equal(defaultDockerHost, e.Host)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// ignoreFile lists patterns of container paths to leave out of the changes,
+// one per line, like .dockerignore
+const ignoreFile = ".cyclopsignore"
+
+// defaultIgnore hides the bind mounted working directory
+var defaultIgnore = []string{"/work"}
+
+// readIgnoreFile returns the patterns in the ignore file at path. Blank
+// lines and comments are skipped; a missing file has no patterns.
+func readIgnoreFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// Ignore adds patterns that hide matching paths, and everything below them,
+// from the changes of each step
+func (w *Workspace) Ignore(patterns ...string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	w.ignore = append(w.ignore, patterns...)
+	return nil
+}
+
+// filterChanges returns the changes that don't match an ignore pattern and
+// the number of changes that were hidden. Changes hidden by the default
+// patterns aren't counted, only the ones the user asked to ignore.
+func (w *Workspace) filterChanges(changes []docker.Change) ([]docker.Change, int) {
+	patterns := cleanPatterns(w.ignore)
+	defaults := cleanPatterns(defaultIgnore)
+	kept := []docker.Change{}
+	hidden := 0
+	for _, change := range changes {
+		switch {
+		case !matchPath(change.Path, patterns):
+			kept = append(kept, change)
+		case !matchPath(change.Path, defaults):
+			hidden++
+		}
+	}
+	return kept, hidden
+}
+
+// cleanPatterns makes patterns relative to the root, as matchPath expects
+func cleanPatterns(patterns []string) []string {
+	res := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		res[i] = strings.TrimPrefix(path.Clean(pattern), "/")
+	}
+	return res
+}
+
+// matchPath reports whether p or one of its parent directories matches
+func matchPath(p string, patterns []string) bool {
+	for rel := strings.TrimPrefix(path.Clean(p), "/"); rel != "" && rel != "."; rel = path.Dir(rel) {
+		if matched, _ := fileutils.Matches(rel, patterns); matched {
+			return true
+		}
+	}
+	return false
+}
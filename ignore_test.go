@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadIgnoreFile(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+
+	patterns, err := readIgnoreFile(".test/.cyclopsignore")
+	assert.NoError(err)
+	assert.Len(patterns, 0)
+
+	ioutil.WriteFile(".test/.cyclopsignore", []byte("# apt noise\n/var/cache/apt\n\n/var/lib/apt/lists\n"), 0644)
+	patterns, err = readIgnoreFile(".test/.cyclopsignore")
+	assert.NoError(err)
+	assert.Equal([]string{"/var/cache/apt", "/var/lib/apt/lists"}, patterns)
+}
+
+func TestFilterChanges(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	changes := []docker.Change{
+		{Path: "/etc/nginx/nginx.conf", Kind: 1},
+		{Path: "/tmp", Kind: 0},
+		{Path: "/tmp/apt.log", Kind: 1},
+		{Path: "/var/cache/apt/archives/nginx.deb", Kind: 1},
+		{Path: "/work", Kind: 0},
+	}
+
+	// the working directory is hidden without being counted
+	kept, hidden := ws.filterChanges(changes)
+	assert.Equal(0, hidden)
+	assert.Len(kept, 4)
+
+	assert.NoError(ws.Ignore("/var/cache/apt", "tmp/*"))
+	kept, hidden = ws.filterChanges(changes)
+	assert.Equal(2, hidden)
+	assert.Equal([]docker.Change{changes[0], changes[1]}, kept)
+
+	assert.Error(ws.Ignore("/var/[cache"))
+	assert.Len(ws.ignore, 3)
+}
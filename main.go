@@ -2,16 +2,14 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"os"
-	"strconv"
 	"strings"
 	"text/tabwriter"
 
 	"github.com/fatih/color"
 	"github.com/fsouza/go-dockerclient"
-	"github.com/peterh/liner"
 )
 
 const (
@@ -32,34 +30,104 @@ func help() {
 :r, :run       [command ...]  execute shell command (auto commits image)
 :c, :commit                   commit changes from last command
 :b, :back      [num]          go back in the history (default: 1)
-:hs, :history                 show the current history
-:w, :write     [path/to/file] write state to file
+:sh, :shell    [command]      open an interactive shell in the current image,
+                              offering to commit its changes afterwards
+:d, :diff      [path]         show the content changes of the last command
+:matrix        [image,...] [command ...]
+                              evaluate a command on several images at once
+                              and compare the results
+:changes                      list the paths changed by the last command
+:service       [start|stop|logs|list] [name] [command ...]
+                              run a command in the background, reachable by
+                              name from later commands (--publish=8080:80)
+:assert        [command ...]  check that a command exits 0
+:assert-file   [path] [contains text]
+                              check that a file exists, containing text
+:assert-changed [glob]        check that a committed step changed a path
+:ignore        [pattern ...]  hide matching paths from the changes
+:hs, :history  [--tree]       show the current history, or all branches
+:mark          [name]         name the current step as a checkpoint
+:co, :checkout [mark|image|#item]
+                              switch to a mark, committed image or tree item,
+                              keeping later steps as a branch
+:p, :print     [--format=sh]  print state (formats: dockerfile, sh)
+:w, :write     [path/to/file] write state to file (accepts --format, --squash)
+:cp, :copy     [src] [dest]   copy local files into the image (COPY)
+:env           [key=value]    set environment variables (ENV)
+:workdir       [path]         set the working directory (WORKDIR)
+:user          [user]         set the user commands run as (USER)
+:expose        [port ...]     expose ports (EXPOSE)
+:cmd           [command]      set the default command (CMD)
+:entrypoint    [command]      set the entrypoint (ENTRYPOINT)
+:label         [key=value]    add labels (LABEL)
+:volume        [path ...]     declare volumes (VOLUME)
+:t, :tag       [repo:tag]     tag the current image
+:save          [file.tar]     save the current image for docker load
+:squash        [--flatten]    merge consecutive RUN steps, optionally into a
+                              single layer image ([repo:tag])
+:set           [option=value] set container options for evaluations: bind, env,
+                              network, user, memory, cpu-shares, privileged,
+                              cap-add, cap-drop (an empty value resets)
+:config                       show the container options and image config
+:cache         [clear]        show or clear the cache of committed :run steps
+:m, :mode      [mode]         show or set the mode (default: bash)
+:i, :import    [path/to/file] replay a Dockerfile into the history
+:s, :session   [save|load]    save or restore the session file
+:record        [file.json]    record the following commands and their results
+                              for cyclops replay (no argument stops)
 :q, :quit                     quit cyclops - <ctrl-d>
 `
 	fmt.Println(usage)
 }
 
-func printResults(res EvalResult) {
+// printResults prints the outcome of a step. Steps that changed packages
+// show a package summary instead of the full list of changed paths.
+func printResults(res EvalResult, packages []PackageChange, hidden int) {
 	fmt.Println()
+	if res.Interrupted != "" {
+		color.Red("Stopped: %s", res.Interrupted)
+	}
 	fmt.Println("Exit:", res.Code)
+	if res.Setup > 0 {
+		fmt.Println("Create:", res.Setup)
+	}
 	fmt.Println("Took:", res.Duration)
 	fmt.Println("From:", res.Image)
-	if res.NewImage != "" {
-		fmt.Println("Committed:", res.NewImage[:12])
+	if res.Cached {
+		fmt.Println("Cached:", shortId(res.NewImage), "(reused from an identical step)")
+	} else if res.NewImage != "" {
+		fmt.Println("Committed:", shortId(res.NewImage))
 	}
-	printChanges(res.Changes)
+	if len(packages) == 0 {
+		printChanges(res.Changes, hidden)
+		return
+	}
+	printPackages(packages)
+	fmt.Printf("(%d paths changed, :changes to list them)\n", len(res.Changes))
 }
 
-func printChanges(changes []docker.Change) {
+func printPackages(packages []PackageChange) {
+	fmt.Println("Packages:")
+	for _, p := range packages {
+		switch {
+		case p.Old == "":
+			color.Green("+ %s %s (%s)", p.Name, p.New, p.Manager)
+		case p.New == "":
+			color.Red("- %s %s (%s)", p.Name, p.Old, p.Manager)
+		default:
+			color.Yellow("~ %s %s -> %s (%s)", p.Name, p.Old, p.New, p.Manager)
+		}
+	}
+}
+
+// printChanges prints the changes that weren't hidden by ignore patterns
+func printChanges(changes []docker.Change, hidden int) {
 	fmt.Println("Changes:")
 	if len(changes) == 0 {
 		fmt.Println("<none>")
 	}
 	prunedChanges := pruneChanges(changes)
 	for _, change := range prunedChanges {
-		if change.Path == "/work" {
-			continue
-		}
 		switch change.Kind {
 		case 0:
 			color.Yellow("~ %s", change.Path)
@@ -69,6 +137,29 @@ func printChanges(changes []docker.Change) {
 			color.Red("- %s", change.Path)
 		}
 	}
+	if hidden > 0 {
+		fmt.Printf("(%d ignored)\n", hidden)
+	}
+}
+
+func printDiff(lines []string) {
+	if len(lines) == 0 {
+		fmt.Println("<no differences>")
+	}
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			color.White("%s", line)
+		case strings.HasPrefix(line, "@@"):
+			color.Cyan("%s", line)
+		case strings.HasPrefix(line, "+"):
+			color.Green("%s", line)
+		case strings.HasPrefix(line, "-"):
+			color.Red("%s", line)
+		default:
+			fmt.Println(line)
+		}
+	}
 }
 
 func printHistory(history []EvalResult, currentImage string) {
@@ -92,18 +183,177 @@ func printHistory(history []EvalResult, currentImage string) {
 			}
 			n += 1
 		}
-		row += fmt.Sprintf("%s\t", entry.Command)
-		row += fmt.Sprintf("%d\t", entry.Code)
-		if len(entry.NewImage) == 64 {
-			row += fmt.Sprintf("%s\t", entry.NewImage[:12])
-		} else {
-			row += fmt.Sprintf("%s\t", entry.NewImage)
-		}
+		row += fmt.Sprintf("%s\t", entry.Label())
+		row += fmt.Sprintf("%s\t", exitStatus(entry))
+		row += fmt.Sprintf("%s\t", shortId(entry.NewImage))
 		fmt.Fprintln(w, row)
 	}
 	w.Flush()
 }
 
+// exitStatus formats the exit code of a step, noting stopped and cached steps
+func exitStatus(entry EvalResult) string {
+	switch {
+	case entry.Interrupted != "":
+		return fmt.Sprintf("%d (%s)", entry.Code, entry.Interrupted)
+	case entry.Cached:
+		return fmt.Sprintf("%d (cached)", entry.Code)
+	}
+	return fmt.Sprintf("%d", entry.Code)
+}
+
+// printTree prints the history as a tree of branches, marking the head
+// and the checkpoints on each step. Discarded steps are not shown.
+func printTree(ws *Workspace) {
+	children := map[int][]int{}
+	for i, entry := range ws.history {
+		if !entry.Deleted {
+			children[entry.Parent] = append(children[entry.Parent], i)
+		}
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(w, "Item\tCommand\tExit\tCreated Image\tMarks")
+
+	row := func(i int, prefix string, command string, status string, image string) {
+		item := "  "
+		if i == ws.head {
+			item = "> "
+		}
+		if i > -1 {
+			item += fmt.Sprintf("%d", i+1)
+		}
+		fmt.Fprintf(w, "%s\t%s%s\t%s\t%s\t%s\n", item, prefix, command, status, shortId(image), strings.Join(ws.marksAt(i), ", "))
+	}
+	row(-1, "", "FROM "+ws.Image, "0", "")
+
+	var walk func(parent int, indent string)
+	walk = func(parent int, indent string) {
+		for n, i := range children[parent] {
+			prefix, next := indent, indent
+			if len(children[parent]) > 1 {
+				if n < len(children[parent])-1 {
+					prefix, next = indent+"|- ", indent+"|  "
+				} else {
+					prefix, next = indent+"`- ", indent+"   "
+				}
+			}
+			entry := ws.history[i]
+			row(i, prefix, entry.Label(), exitStatus(entry), entry.NewImage)
+			walk(i, next)
+		}
+	}
+	walk(-1, "")
+	w.Flush()
+}
+
+// printMatrix compares the results of a command on each image
+func printMatrix(results []MatrixResult) {
+	fmt.Println()
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Image\tExit\tTook\tChanges")
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(w, "%s\t-\t-\t-\t%s\n", r.Image, r.Error)
+		case r.Result.Interrupted != "":
+			fmt.Fprintf(w, "%s\t%d (%s)\t%s\t%d\n", r.Image, r.Result.Code, r.Result.Interrupted, r.Result.Duration, r.Changes)
+		default:
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\n", r.Image, r.Result.Code, r.Result.Duration, r.Changes)
+		}
+	}
+	w.Flush()
+}
+
+// printServices lists the running services
+func printServices(services []Service) {
+	if len(services) == 0 {
+		fmt.Println("No services running")
+		return
+	}
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Name\tContainer\tPorts\tCommand")
+	for _, svc := range services {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", svc.Name, shortId(svc.Id), strings.Join(svc.Ports, ","), svc.Command)
+	}
+	w.Flush()
+}
+
+// printAssertion prints the outcome of an assertion
+func printAssertion(res EvalResult) {
+	if res.Code == 0 {
+		color.Green("Passed: %s", res.Label())
+		return
+	}
+	if res.Interrupted != "" {
+		color.Red("Failed: %s (exit %d, %s)", res.Label(), res.Code, res.Interrupted)
+		return
+	}
+	color.Red("Failed: %s (exit %d)", res.Label(), res.Code)
+}
+
+// printDrifts prints the steps of a replay that drifted from the transcript
+func printDrifts(steps int, drifts []Drift) {
+	fmt.Println()
+	if len(drifts) == 0 {
+		color.Green("Replayed %d steps without drift", steps)
+		return
+	}
+	for _, d := range drifts {
+		color.Red("Step %d (%s): %s drifted", d.Step, d.Input, d.What)
+		for _, line := range d.Lines {
+			fmt.Println("  " + line)
+		}
+	}
+	fmt.Printf("%d of %d steps drifted\n", driftedSteps(drifts), steps)
+}
+
+func driftedSteps(drifts []Drift) int {
+	steps := map[int]bool{}
+	for _, d := range drifts {
+		steps[d.Step] = true
+	}
+	return len(steps)
+}
+
+// printConfig prints the runtime options and the config set by directives
+func printConfig(ws *Workspace) {
+	fmt.Println("Container options:")
+	lines := ws.Runtime.Lines()
+	if len(lines) == 0 {
+		fmt.Println("<defaults>")
+	}
+	for _, line := range lines {
+		fmt.Println("  " + line)
+	}
+
+	fmt.Println("Image config:")
+	config := ws.Config()
+	if len(config.Env) == 0 && config.WorkingDir == "" && config.User == "" {
+		fmt.Println("<defaults>")
+	}
+	for _, env := range config.Env {
+		fmt.Println("  env:        " + env)
+	}
+	if config.WorkingDir != "" {
+		fmt.Println("  workdir:    " + config.WorkingDir)
+	}
+	if config.User != "" {
+		fmt.Println("  user:       " + config.User)
+	}
+}
+
+// shortId truncates full 64 character ids the way the docker cli does
+func shortId(id string) string {
+	if len(id) == 64 {
+		return id[:12]
+	}
+	return id
+}
+
 func pruneChanges(changes []docker.Change) []docker.Change {
 	var p string
 	c := []docker.Change{}
@@ -139,9 +389,77 @@ func parseCommand(input string) (string, string, error) {
 	case ":help", ":h":
 		return "help", "", nil
 	case ":print", ":p":
-		return "print", "", nil
+		if len(parts) < 2 {
+			return "print", "", nil
+		}
+		return "print", parts[1], nil
 	case ":history", ":hs":
-		return "history", "", nil
+		if len(parts) < 2 {
+			return "history", "", nil
+		}
+		return "history", parts[1], nil
+	case ":shell", ":sh":
+		if len(parts) < 2 {
+			return "shell", "", nil
+		}
+		return "shell", parts[1], nil
+	case ":diff", ":d":
+		if len(parts) < 2 {
+			return "diff", "", nil
+		}
+		return "diff", parts[1], nil
+	case ":record":
+		if len(parts) < 2 {
+			return "record", "", nil
+		}
+		return "record", parts[1], nil
+	case ":cache":
+		if len(parts) < 2 {
+			return "cache", "", nil
+		}
+		return "cache", parts[1], nil
+	case ":service":
+		if len(parts) < 2 {
+			return "service", "", nil
+		}
+		return "service", parts[1], nil
+	case ":matrix":
+		if len(parts) < 2 {
+			return "matrix", "", ErrMissingRequiredArg
+		}
+		return "matrix", parts[1], nil
+	case ":assert":
+		if len(parts) < 2 {
+			return "assert", "", ErrMissingRequiredArg
+		}
+		return "assert", parts[1], nil
+	case ":assert-file":
+		if len(parts) < 2 {
+			return "assert-file", "", ErrMissingRequiredArg
+		}
+		return "assert-file", parts[1], nil
+	case ":assert-changed":
+		if len(parts) < 2 {
+			return "assert-changed", "", ErrMissingRequiredArg
+		}
+		return "assert-changed", parts[1], nil
+	case ":changes":
+		return "changes", "", nil
+	case ":ignore":
+		if len(parts) < 2 {
+			return "ignore", "", nil
+		}
+		return "ignore", parts[1], nil
+	case ":mark":
+		if len(parts) < 2 {
+			return "mark", "", nil
+		}
+		return "mark", parts[1], nil
+	case ":checkout", ":co":
+		if len(parts) < 2 {
+			return "checkout", "", ErrMissingRequiredArg
+		}
+		return "checkout", parts[1], nil
 	case ":quit", ":q":
 		return "quit", "", nil
 	case ":eval", ":e":
@@ -164,6 +482,53 @@ func parseCommand(input string) (string, string, error) {
 			return "write", "", ErrMissingRequiredArg
 		}
 		return "write", parts[1], nil
+	case ":env", ":workdir", ":user", ":expose", ":cmd", ":entrypoint", ":label", ":volume":
+		if len(parts) < 2 {
+			return parts[0][1:], "", ErrMissingRequiredArg
+		}
+		return parts[0][1:], parts[1], nil
+	case ":copy", ":cp":
+		if len(parts) < 2 {
+			return "copy", "", ErrMissingRequiredArg
+		}
+		return "copy", parts[1], nil
+	case ":import", ":i":
+		if len(parts) < 2 {
+			return "import", "", ErrMissingRequiredArg
+		}
+		return "import", parts[1], nil
+	case ":tag", ":t":
+		if len(parts) < 2 {
+			return "tag", "", ErrMissingRequiredArg
+		}
+		return "tag", parts[1], nil
+	case ":save":
+		if len(parts) < 2 {
+			return "save", "", ErrMissingRequiredArg
+		}
+		return "save", parts[1], nil
+	case ":squash":
+		if len(parts) < 2 {
+			return "squash", "", nil
+		}
+		return "squash", parts[1], nil
+	case ":set":
+		if len(parts) < 2 {
+			return "set", "", ErrMissingRequiredArg
+		}
+		return "set", parts[1], nil
+	case ":config":
+		return "config", "", nil
+	case ":mode", ":m":
+		if len(parts) < 2 {
+			return "mode", "", nil
+		}
+		return "mode", parts[1], nil
+	case ":session", ":s":
+		if len(parts) < 2 {
+			return "session", "", ErrMissingRequiredArg
+		}
+		return "session", parts[1], nil
 	case ":back", ":b":
 		if len(parts) < 2 {
 			return "back", "1", nil
@@ -174,9 +539,10 @@ func parseCommand(input string) (string, string, error) {
 	}
 }
 
-func preExit(ws *Workspace) {
+func preExit(ws *Workspace) []ResetResult {
 	fmt.Println("Cleaning up...")
-	lines := ws.Reset()
+	ws.pool.close()
+	lines := append(ws.StopServices(), ws.Reset()...)
 	for _, line := range lines {
 		if line.Err != nil {
 			fmt.Println(line.Err)
@@ -185,124 +551,212 @@ func preExit(ws *Workspace) {
 		}
 	}
 	fmt.Println("Done")
+	return lines
+}
+
+// parseSessionArgs splits `:session` arguments into the action and file path,
+// falling back to the --session path when none is given
+func parseSessionArgs(args string, defaultPath string) (string, string, error) {
+	parts := strings.Fields(args)
+	if len(parts) == 0 {
+		return "", "", ErrMissingRequiredArg
+	}
+	action := parts[0]
+	if action != "save" && action != "load" {
+		return "", "", ErrInvalidCommand
+	}
+	path := defaultPath
+	if len(parts) > 1 {
+		path = parts[1]
+	}
+	if path == "" {
+		return action, "", ErrMissingRequiredArg
+	}
+	return action, path, nil
 }
 
 func main() {
-	dc, err := NewDockerClient(os.Getenv("DOCKER_HOST"), os.Getenv("DOCKER_TLS_VERIFY"), os.Getenv("DOCKER_CERT_PATH"))
+	var sessionPath, scriptPath, importPath, mode, output, pull string
+	var keepGoing, keep, noCache bool
+	var poolSize int
+	var runtimeFlags [][2]string
+	var endpointOpts endpointOptions
+	flag.StringVar(&mode, "mode", defaultMode, "how commands are executed and written: "+strings.Join(modeNames(), ", "))
+	flag.StringVar(&sessionPath, "session", "", "session file to resume from and autosave to")
+	flag.StringVar(&scriptPath, "f", "", "run the commands in the file non-interactively")
+	flag.StringVar(&importPath, "import", "", "replay a Dockerfile into the history on startup")
+	flag.BoolVar(&keep, "keep", false, "keep containers on quit instead of cleaning them up")
+	flag.BoolVar(&noCache, "no-cache", false, "run every :run step instead of reusing images of identical steps")
+	flag.BoolVar(&keepGoing, "keep-going", false, "in batch mode, continue after a failing step")
+	flag.IntVar(&poolSize, "pool", 0, "number of containers to create ahead for the current image, 0 to disable")
+	for _, key := range runtimeOptions {
+		flag.Var(runtimeFlag{key, &runtimeFlags}, key, "container option for evaluations, like :set "+key)
+	}
+	flag.StringVar(&endpointOpts.Host, "host", "", "docker daemon to connect to, defaults to DOCKER_HOST or "+defaultDockerHost)
+	flag.BoolVar(&endpointOpts.TLS, "tls", false, "use TLS without verifying the daemon")
+	flag.BoolVar(&endpointOpts.TLSVerify, "tlsverify", false, "use TLS and verify the daemon, like DOCKER_TLS_VERIFY")
+	flag.StringVar(&endpointOpts.CACert, "tlscacert", "", "CA certificate to verify the daemon (default: ca.pem in DOCKER_CERT_PATH or ~/.docker)")
+	flag.StringVar(&endpointOpts.Cert, "tlscert", "", "client certificate (default: cert.pem in DOCKER_CERT_PATH or ~/.docker)")
+	flag.StringVar(&endpointOpts.Key, "tlskey", "", "client key (default: key.pem in DOCKER_CERT_PATH or ~/.docker)")
+	flag.StringVar(&pull, "pull", pullMissing, "when to pull base images: always, missing or never")
+	flag.StringVar(&output, "output", "text", "output format: text, or json for one JSON object per command")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: cyclops [options] [replay transcript.json]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var replayPath string
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "replay" || len(args) != 2 {
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		replayPath = args[1]
+	}
+
+	var rep *reporter
+	switch output {
+	case "text":
+	case "json":
+		// stdout is reserved for the reports, everything else goes to stderr
+		rep = newReporter(os.Stdout)
+		os.Stdout = os.Stderr
+		color.Output = os.Stderr
+	default:
+		fmt.Println("Invalid output format:", output)
+		os.Exit(exitUsage)
+	}
+
+	endpoint, err := discoverEndpoint(endpointOpts, os.Getenv)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	if err := dc.Ping(); err != nil {
+	dc, err := NewDockerClient(endpoint)
+	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	fmt.Println("Connected to docker daemon...")
-
-	ws := NewWorkspace(dc, "bash", defaultImage)
-
-	line := liner.NewLiner()
-	defer line.Close()
-
-	prompt := defaultPrompt
-
-	if f, err := os.Open("/tmp/.cyclops_history"); err == nil {
-		line.ReadHistory(f)
-		f.Close()
+	if err := dc.Ping(); err != nil {
+		fmt.Printf("Cannot connect to docker daemon at %s: %v\n", endpoint, err)
+		os.Exit(1)
 	}
+	fmt.Println("Connected to docker daemon at", endpoint)
 
-	defer preExit(ws)
+	ws := NewWorkspace(dc, defaultMode, defaultImage)
+	patterns, err := readIgnoreFile(ignoreFile)
+	if err == nil {
+		err = ws.Ignore(patterns...)
+	}
+	if err != nil {
+		fmt.Println("error reading "+ignoreFile+":", err)
+		os.Exit(exitUsage)
+	}
+	if err := ws.SetMode(mode); err != nil {
+		fmt.Println(err, mode)
+		os.Exit(exitUsage)
+	}
+	switch pull {
+	case pullAlways, pullMissing, pullNever:
+		ws.Pull = pull
+	default:
+		fmt.Println(ErrInvalidPullPolicy)
+		os.Exit(exitUsage)
+	}
+	ws.NoCache = noCache
+	if poolSize > 0 {
+		ws.pool = newContainerPool(dc, poolSize)
+	}
+	if ws.cache, err = loadCache(cachePath(os.Getenv)); err != nil {
+		fmt.Println("error reading cache, starting with an empty cache:", err)
+	}
+	config, err := readDockerConfig(dockerConfigDir(os.Getenv))
+	if err == nil {
+		ws.auths, err = dockerAuths(config)
+	}
+	if err != nil {
+		fmt.Println("error reading docker config:", err)
+	}
 
-mainloop:
-	for {
-		input, err := line.Prompt(prompt + "> ")
-		if err == io.EOF {
-			fmt.Println() //Returns user to prompt on a new line
-			break mainloop
+	var transcript *Transcript
+	resumed := false
+	if replayPath != "" {
+		transcript, err = loadTranscript(replayPath)
+		if err == nil {
+			err = transcript.apply(ws)
 		}
-		command, args, err := parseCommand(input)
 		if err != nil {
-			fmt.Println(err, command)
-			continue
-		}
-		switch command {
-		case "help":
-			help()
-			continue
-		case "quit":
-			break mainloop
-		case "commit":
-			if id, err := ws.CommitLast(); err != nil {
-				fmt.Println(err)
-			} else {
-				fmt.Println("Committed:", id)
-			}
-		case "eval":
-			if res, err := ws.Eval(args); err != nil {
-				fmt.Println(err)
-			} else {
-				printResults(res)
-			}
-		case "from":
-			if ws.CurrentImage != ws.Image {
-				confirm, err := line.Prompt("Changes will be lost. Continue? <y>: ")
-				if err != nil || confirm != "y" {
-					fmt.Println("Aborted")
-					continue
-				}
-				fmt.Println("Wiping history to set new base image")
-			}
-			ws.Reset()
-			if err := ws.SetImage(args); err != nil {
-				fmt.Println("error setting image:", err)
-			} else {
-				fmt.Println("Image: ", args)
-			}
-		case "back":
-			num, err := strconv.Atoi(args)
-			if err != nil {
-				fmt.Println("Error: invalid number specified")
-				break
-			}
-			if err := ws.back(num); err != nil {
-				fmt.Println("Error:", err)
+			fmt.Println("Error loading transcript:", err)
+			os.Exit(exitUsage)
+		}
+	} else if sessionPath != "" {
+		if _, err := os.Stat(sessionPath); err == nil {
+			if dropped, err := ws.Load(sessionPath); err != nil {
+				fmt.Println("Error loading session:", err)
+				os.Exit(1)
 			} else {
-				fmt.Printf("Back %d to %s\n", num, ws.CurrentImage)
-			}
-		case "history":
-			printHistory(ws.history, ws.CurrentImage)
-		case "print":
-			if out, err := ws.Sprint(); err == nil {
-				for _, line := range out {
-					fmt.Println(line)
+				resumed = true
+				fmt.Println("Resumed session:", sessionPath)
+				if dropped > 0 {
+					fmt.Printf("Dropped %d steps with missing images\n", dropped)
 				}
 			}
-		case "run":
-			if res, err := ws.Run(args); err != nil {
-				fmt.Println(err)
-			} else {
-				printResults(res)
-			}
-		case "write":
-			if args == "" {
-				fmt.Println("Missing file path: `:write [path/to/file]`")
-				break
-			}
-			if err := ws.Write(args); err != nil {
-				fmt.Println("Error writing file:", err)
-			} else {
-				fmt.Println("File written:", args)
-			}
-		default:
-			continue
 		}
+	}
+	// a new session starts from the default image unless the import sets one
+	if transcript == nil && !resumed && importPath == "" {
+		if err := ws.SetImage(ws.Image); err != nil {
+			fmt.Println("error setting image:", err)
+		}
+	}
+	// command line options take precedence over the session
+	for _, opt := range runtimeFlags {
+		ws.Runtime.Set(opt[0], opt[1])
+	}
+	ws.warm()
 
-		line.AppendHistory(input)
-		if f, err := os.Create("/tmp/.cyclops_history"); err != nil {
-			fmt.Println("error writing history:", err)
-		} else {
-			line.WriteHistory(f)
-			f.Close()
+	c := newCli(ws, sessionPath)
+	c.reporter = rep
+	status := exitOK
+	interactive := scriptPath == "" && isTerminal(os.Stdin)
+	if importPath != "" {
+		if err := c.execute("import", importPath); err != nil && !interactive {
+			c.cleanup()
+			os.Exit(exitFailure)
 		}
 	}
+
+	switch {
+	case transcript != nil:
+		status = c.replay(transcript)
+	case scriptPath != "":
+		f, err := os.Open(scriptPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(exitUsage)
+		}
+		status = c.batch(f, keepGoing)
+		f.Close()
+	case !interactive:
+		status = c.batch(os.Stdin, keepGoing)
+	default:
+		c.interactive()
+	}
+
+	if keep {
+		ws.pool.close()
+		fmt.Println("Keeping containers, current image:", ws.CurrentImage)
+	} else {
+		c.cleanup()
+	}
+	os.Exit(status)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
@@ -24,7 +24,8 @@ func TestParseCommand(t *testing.T) {
 		{":commit this", "commit", "", nil},
 		{":commit", "commit", "", nil},
 		{":c", "commit", "", nil},
-		{":print this", "print", "", nil},
+		{":print this", "print", "this", nil},
+		{":print --format=sh", "print", "--format=sh", nil},
 		{":print", "print", "", nil},
 		{":p", "print", "", nil},
 		{":eval apt-get update", "eval", "apt-get update", nil},
@@ -42,8 +43,58 @@ func TestParseCommand(t *testing.T) {
 		{":f", "from", "", ErrMissingRequiredArg},
 		{":write Dockerfile", "write", "Dockerfile", nil},
 		{":w Dockerfile", "write", "Dockerfile", nil},
+		{":write --format=sh install.sh", "write", "--format=sh install.sh", nil},
 		{":write", "write", "", ErrMissingRequiredArg},
 		{":w", "write", "", ErrMissingRequiredArg},
+		{":env FOO=bar", "env", "FOO=bar", nil},
+		{":workdir /app", "workdir", "/app", nil},
+		{":user nobody", "user", "nobody", nil},
+		{":expose 80 443", "expose", "80 443", nil},
+		{":cmd nginx -g daemon off;", "cmd", "nginx -g daemon off;", nil},
+		{":entrypoint [\"top\"]", "entrypoint", "[\"top\"]", nil},
+		{":label version=1.0", "label", "version=1.0", nil},
+		{":volume /data", "volume", "/data", nil},
+		{":env", "env", "", ErrMissingRequiredArg},
+		{":tag cyclops/nginx:1.0", "tag", "cyclops/nginx:1.0", nil},
+		{":t", "tag", "", ErrMissingRequiredArg},
+		{":save nginx.tar", "save", "nginx.tar", nil},
+		{":save", "save", "", ErrMissingRequiredArg},
+		{":squash", "squash", "", nil},
+		{":squash --flatten cyclops/flat", "squash", "--flatten cyclops/flat", nil},
+		{":diff /etc/nginx/nginx.conf", "diff", "/etc/nginx/nginx.conf", nil},
+		{":d", "diff", "", nil},
+		{":shell", "shell", "", nil},
+		{":sh /bin/zsh", "shell", "/bin/zsh", nil},
+		{":matrix ubuntu:trusty,centos:7 make", "matrix", "ubuntu:trusty,centos:7 make", nil},
+		{":matrix", "matrix", "", ErrMissingRequiredArg},
+		{":cache clear", "cache", "clear", nil},
+		{":service start db postgres", "service", "start db postgres", nil},
+		{":service", "service", "", nil},
+		{":record transcript.json", "record", "transcript.json", nil},
+		{":record", "record", "", nil},
+		{":assert nginx -t", "assert", "nginx -t", nil},
+		{":assert-file /etc/hosts contains localhost", "assert-file", "/etc/hosts contains localhost", nil},
+		{":assert-changed /etc/nginx/*", "assert-changed", "/etc/nginx/*", nil},
+		{":assert-file", "assert-file", "", ErrMissingRequiredArg},
+		{":changes", "changes", "", nil},
+		{":ignore /var/cache/apt /tmp/*", "ignore", "/var/cache/apt /tmp/*", nil},
+		{":ignore", "ignore", "", nil},
+		{":history --tree", "history", "--tree", nil},
+		{":mark before-nginx", "mark", "before-nginx", nil},
+		{":mark", "mark", "", nil},
+		{":checkout before-nginx", "checkout", "before-nginx", nil},
+		{":co", "checkout", "", ErrMissingRequiredArg},
+		{":set network=none memory=512m", "set", "network=none memory=512m", nil},
+		{":set", "set", "", ErrMissingRequiredArg},
+		{":config", "config", "", nil},
+		{":mode python", "mode", "python", nil},
+		{":m", "mode", "", nil},
+		{":import Dockerfile", "import", "Dockerfile", nil},
+		{":i Dockerfile", "import", "Dockerfile", nil},
+		{":import", "import", "", ErrMissingRequiredArg},
+		{":session save cyclops.json", "session", "save cyclops.json", nil},
+		{":s load", "session", "load", nil},
+		{":session", "session", "", ErrMissingRequiredArg},
 		{":notreal", ":notreal", "", ErrInvalidCommand},
 		{"", "", "", nil},
 	}
@@ -59,6 +110,31 @@ func TestParseCommand(t *testing.T) {
 	}
 }
 
+func TestParseSessionArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		Args    string
+		Default string
+		Action  string
+		Path    string
+		Error   error
+	}{
+		{"save cyclops.json", "", "save", "cyclops.json", nil},
+		{"load cyclops.json", "default.json", "load", "cyclops.json", nil},
+		{"save", "default.json", "save", "default.json", nil},
+		{"save", "", "save", "", ErrMissingRequiredArg},
+		{"", "default.json", "", "", ErrMissingRequiredArg},
+		{"banana", "default.json", "", "", ErrInvalidCommand},
+	}
+	for _, c := range cases {
+		action, path, err := parseSessionArgs(c.Args, c.Default)
+		assert.Equal(c.Action, action, "Action should be equal for %s", c.Args)
+		assert.Equal(c.Path, path, "Path should be equal for %s", c.Args)
+		assert.Equal(c.Error, err, "Error should be equal for %s", c.Args)
+	}
+}
+
 func TestPruneChanges(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+var ErrNoImages = errors.New("No images given")
+
+// MatrixResult is the outcome of a :matrix command on one image. The
+// container is removed once its changes are known.
+type MatrixResult struct {
+	Image   string     `json:"image"`
+	Result  EvalResult `json:"result"`
+	Changes int        `json:"changes"`         //changes left after ignore patterns
+	Error   string     `json:"error,omitempty"` //set when the command couldn't be run
+}
+
+// Matrix evaluates command against each image concurrently, with the
+// config and container options of the workspace. Output is streamed to out
+// with each line prefixed by its image. Nothing is added to the history.
+func (w *Workspace) Matrix(images []string, command string, out io.Writer) ([]MatrixResult, error) {
+	if len(images) == 0 {
+		return nil, ErrNoImages
+	}
+
+	results := make([]MatrixResult, len(images))
+	lock := &sync.Mutex{}
+	var wg sync.WaitGroup
+	for i, image := range images {
+		wg.Add(1)
+		go func(i int, image string) {
+			defer wg.Done()
+			prefixed := &prefixWriter{prefix: "[" + image + "] ", out: out, lock: lock}
+			opts := EvalOptions{
+				Cmd:     w.mode().Cmd(command),
+				Config:  w.Config(),
+				Runtime: w.Runtime,
+				Cancel:  w.cancel,
+				Output:  prefixed,
+			}
+			res, err := Eval(w.docker, command, image, opts)
+			prefixed.Flush()
+			res.Mode = w.Mode
+			res.BaseImage = image
+			if res.Id != "" {
+				RemoveContainer(w.docker, res.Id)
+			}
+			result := MatrixResult{Image: image, Result: res}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			changes, _ := w.filterChanges(res.Changes)
+			result.Changes = len(changes)
+			results[i] = result
+		}(i, image)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// parseMatrix splits `image,image command` into the images and the command
+func parseMatrix(args string) ([]string, string) {
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	images := []string{}
+	for _, image := range strings.Split(parts[0], ",") {
+		if image = strings.TrimSpace(image); image != "" {
+			images = append(images, image)
+		}
+	}
+	if len(parts) < 2 {
+		return images, ""
+	}
+	return images, strings.TrimSpace(parts[1])
+}
+
+// prefixWriter writes complete lines to out, each starting with prefix.
+// Writers sharing a lock don't interleave their lines.
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	lock   *sync.Mutex
+	line   []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.line = append(p.line, b...)
+	for {
+		i := bytes.IndexByte(p.line, '\n')
+		if i < 0 {
+			break
+		}
+		p.writeLine(p.line[:i+1])
+		p.line = p.line[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes a last line that didn't end with a newline
+func (p *prefixWriter) Flush() {
+	if len(p.line) > 0 {
+		p.writeLine(append(p.line, '\n'))
+		p.line = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	io.WriteString(p.out, p.prefix)
+	p.out.Write(line)
+}
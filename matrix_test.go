@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatrix(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	ws.Directive(directives["env"], "DEBIAN_FRONTEND=noninteractive")
+
+	images := []string{"ubuntu:trusty", "debian:jessie", "centos:7"}
+	mockdock.Outputs = map[string]string{}
+	for _, image := range images {
+		mockdock.Outputs[image] = "installed on " + image
+	}
+	out := new(bytes.Buffer)
+	results, err := ws.Matrix(images, "./install.sh", out)
+	assert.NoError(err)
+	assert.Len(results, 3)
+	for i, r := range results {
+		assert.Equal(images[i], r.Image)
+		assert.Equal(images[i], r.Result.Image)
+		assert.Equal("./install.sh", r.Result.Command)
+		assert.Equal("", r.Error)
+		assert.Equal("installed on "+images[i], string(r.Result.Log.Bytes()))
+		assert.Contains(out.String(), "["+images[i]+"] installed on "+images[i]+"\n")
+	}
+	assert.Len(mockdock.Containers, 0)
+	assert.Len(ws.history, 1)
+	for _, opts := range mockdock.Created {
+		assert.Contains(opts.Config.Env, "DEBIAN_FRONTEND=noninteractive")
+	}
+
+	mockdock.FailStart = true
+	results, err = ws.Matrix(images[:1], "./install.sh", ioutil.Discard)
+	assert.NoError(err)
+	assert.Equal("MOCK: Failed to start container", results[0].Error)
+
+	_, err = ws.Matrix(nil, "./install.sh", ioutil.Discard)
+	assert.Equal(ErrNoImages, err)
+}
+
+func TestParseMatrix(t *testing.T) {
+	assert := assert.New(t)
+
+	images, command := parseMatrix("ubuntu:trusty,debian:jessie, apt-get install -y curl")
+	assert.Equal([]string{"ubuntu:trusty", "debian:jessie"}, images)
+	assert.Equal("apt-get install -y curl", command)
+
+	images, command = parseMatrix("alpine")
+	assert.Equal([]string{"alpine"}, images)
+	assert.Equal("", command)
+}
+
+func TestPrefixWriter(t *testing.T) {
+	assert := assert.New(t)
+	out := new(bytes.Buffer)
+	lock := &sync.Mutex{}
+	a := &prefixWriter{prefix: "[a] ", out: out, lock: lock}
+	b := &prefixWriter{prefix: "[b] ", out: out, lock: lock}
+
+	a.Write([]byte("one\ntw"))
+	b.Write([]byte("three\n"))
+	a.Write([]byte("o\nfour"))
+	a.Flush()
+	b.Flush()
+	assert.Equal("[a] one\n[b] three\n[a] two\n[a] four\n", out.String())
+}
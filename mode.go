@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const defaultMode = "bash"
+
+var ErrInvalidMode = errors.New("Invalid mode")
+
+// Mode controls how an input line is executed in the container and how
+// the committed history is rendered by :print and :write
+type Mode interface {
+	// Cmd returns the container command that evaluates input
+	Cmd(input string) []string
+	// Render formats the committed history built on top of base
+	Render(base string, history []EvalResult) []string
+}
+
+var modes = map[string]Mode{
+	"bash":    shellMode{"/bin/bash"},
+	"sh":      shellMode{"/bin/sh"},
+	"python":  pythonMode{},
+	"ansible": ansibleMode{},
+	"salt":    saltMode{},
+	"puppet":  puppetMode{},
+}
+
+// modeFor looks up a mode by name, falling back to the default mode
+func modeFor(name string) Mode {
+	if mode, ok := modes[name]; ok {
+		return mode
+	}
+	return modes[defaultMode]
+}
+
+// modeNames returns the names of all registered modes
+func modeNames() []string {
+	names := []string{}
+	for name := range modes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderWith formats the entries of mode with format, preceded by a header
+// recording the base image. Entries from other modes are kept as comments
+// so the prerequisites of a script are still visible.
+func renderWith(mode string, base string, history []EvalResult, format func(int, EvalResult) []string) []string {
+	res := []string{"# validated against " + base}
+	n := 0
+	for _, entry := range history {
+		if entry.Directive == "" && entry.Mode == mode && !entry.Shell && entry.Assert == "" {
+			n += 1
+			res = append(res, format(n, entry)...)
+		} else {
+			res = append(res, commentInstruction(entry))
+		}
+	}
+	return res
+}
+
+// shellMode runs input with a shell and renders a Dockerfile
+type shellMode struct {
+	shell string
+}
+
+func (m shellMode) Cmd(input string) []string {
+	return []string{m.shell, "-c", input}
+}
+
+func (m shellMode) Render(base string, history []EvalResult) []string {
+	res := []string{"FROM " + base}
+	for _, entry := range history {
+		res = append(res, entry.Instruction())
+	}
+	return res
+}
+
+// pythonMode runs input with python -c and renders a python script
+type pythonMode struct{}
+
+func (m pythonMode) Cmd(input string) []string {
+	return []string{"python", "-c", input}
+}
+
+func (m pythonMode) Render(base string, history []EvalResult) []string {
+	res := []string{"#!/usr/bin/env python"}
+	return append(res, renderWith("python", base, history, func(n int, entry EvalResult) []string {
+		return []string{entry.Command}
+	})...)
+}
+
+// ansibleMode runs input as a single task with ansible-playbook against
+// localhost and renders a playbook
+type ansibleMode struct{}
+
+const ansiblePlaybook = "- hosts: all\n  tasks:\n"
+
+func (m ansibleMode) Cmd(input string) []string {
+	playbook := ansiblePlaybook + "    - " + input + "\n"
+	script := `f=$(mktemp) && printf '%s' "$1" > "$f" && ansible-playbook -i localhost, -c local "$f"; rc=$?; rm -f "$f"; exit $rc`
+	return []string{"/bin/sh", "-c", script, "sh", playbook}
+}
+
+func (m ansibleMode) Render(base string, history []EvalResult) []string {
+	res := renderWith("ansible", base, history, func(n int, entry EvalResult) []string {
+		return []string{"    - " + entry.Command}
+	})
+	return append([]string{res[0], "- hosts: all", "  tasks:"}, indentComments(res[1:], "    ")...)
+}
+
+// saltMode applies input as a single state with salt-call, e.g.
+// `pkg.installed name=nginx`, and renders an sls file
+type saltMode struct{}
+
+func (m saltMode) Cmd(input string) []string {
+	return append([]string{"salt-call", "--local", "--retcode-passthrough", "state.single"}, strings.Fields(input)...)
+}
+
+func (m saltMode) Render(base string, history []EvalResult) []string {
+	return renderWith("salt", base, history, func(n int, entry EvalResult) []string {
+		fields := strings.Fields(entry.Command)
+		if len(fields) == 0 {
+			return nil
+		}
+		res := []string{fmt.Sprintf("cyclops-%d:", n), "  " + fields[0] + ":"}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) == 2 {
+				res = append(res, fmt.Sprintf("    - %s: %s", kv[0], kv[1]))
+			}
+		}
+		return res
+	})
+}
+
+// puppetMode applies input with puppet apply -e and renders a manifest
+type puppetMode struct{}
+
+func (m puppetMode) Cmd(input string) []string {
+	return []string{"puppet", "apply", "-e", input}
+}
+
+func (m puppetMode) Render(base string, history []EvalResult) []string {
+	return renderWith("puppet", base, history, func(n int, entry EvalResult) []string {
+		return []string{entry.Command}
+	})
+}
+
+// commentInstruction returns the instruction of entry as a comment
+func commentInstruction(entry EvalResult) string {
+	instruction := entry.Instruction()
+	if strings.HasPrefix(instruction, "#") {
+		return instruction
+	}
+	return "# " + instruction
+}
+
+func indentComments(lines []string, indent string) []string {
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			lines[i] = indent + line
+		}
+	}
+	return lines
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModeFor(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(shellMode{"/bin/bash"}, modeFor("bash"))
+	assert.Equal(shellMode{"/bin/sh"}, modeFor("sh"))
+	assert.Equal(shellMode{"/bin/bash"}, modeFor(""))
+	assert.Equal(shellMode{"/bin/bash"}, modeFor("dockerfile"))
+	assert.Equal(pythonMode{}, modeFor("python"))
+}
+
+func TestModeCmd(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"/bin/sh", "-c", "ls /"}, modeFor("sh").Cmd("ls /"))
+	assert.Equal([]string{"python", "-c", "print(1)"}, modeFor("python").Cmd("print(1)"))
+	assert.Equal([]string{"salt-call", "--local", "--retcode-passthrough", "state.single", "pkg.installed", "name=nginx"}, modeFor("salt").Cmd("pkg.installed name=nginx"))
+	assert.Equal([]string{"puppet", "apply", "-e", "package { 'nginx': }"}, modeFor("puppet").Cmd("package { 'nginx': }"))
+
+	cmd := modeFor("ansible").Cmd("apt: name=nginx")
+	assert.Equal("- hosts: all\n  tasks:\n    - apt: name=nginx\n", cmd[len(cmd)-1])
+}
+
+func TestModeRender(t *testing.T) {
+	assert := assert.New(t)
+
+	history := []EvalResult{
+		{Command: "apt-get install -y ansible python", Mode: "bash"},
+		{Command: "FOO=bar", Directive: "ENV"},
+		{Command: "print(1)", Mode: "python"},
+		{Command: "apt: name=nginx", Mode: "ansible"},
+		{Command: "pkg.installed name=nginx", Mode: "salt"},
+	}
+
+	assert.Equal([]string{
+		"FROM ubuntu:trusty",
+		"RUN apt-get install -y ansible python",
+		"ENV FOO=bar",
+		`RUN ["python","-c","print(1)"]`,
+		`RUN ["/bin/sh","-c","f=$(mktemp) && printf '%s' \"$1\" > \"$f\" && ansible-playbook -i localhost, -c local \"$f\"; rc=$?; rm -f \"$f\"; exit $rc","sh","- hosts: all\n  tasks:\n    - apt: name=nginx\n"]`,
+		`RUN ["salt-call","--local","--retcode-passthrough","state.single","pkg.installed","name=nginx"]`,
+	}, modeFor("bash").Render("ubuntu:trusty", history))
+
+	assert.Equal([]string{
+		"#!/usr/bin/env python",
+		"# validated against ubuntu:trusty",
+		"# RUN apt-get install -y ansible python",
+		"# ENV FOO=bar",
+		"print(1)",
+	}, modeFor("python").Render("ubuntu:trusty", history[:3]))
+
+	assert.Equal([]string{
+		"# validated against ubuntu:trusty",
+		"- hosts: all",
+		"  tasks:",
+		"    # RUN apt-get install -y ansible python",
+		"    - apt: name=nginx",
+	}, modeFor("ansible").Render("ubuntu:trusty", []EvalResult{history[0], history[3]}))
+
+	assert.Equal([]string{
+		"# validated against ubuntu:trusty",
+		"cyclops-1:",
+		"  pkg.installed:",
+		"    - name: nginx",
+	}, modeFor("salt").Render("ubuntu:trusty", history[4:]))
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const maxPackageDBSize = 64 << 20
+
+// PackageChange is a package added, removed or upgraded by a step. Old is
+// empty for added packages and New is empty for removed ones.
+type PackageChange struct {
+	Manager string `json:"manager"`
+	Name    string `json:"name"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+var (
+	pipPackage = regexp.MustCompile(`/(site|dist)-packages/([^/]+)\.(dist-info|egg-info)$`)
+	gemPackage = regexp.MustCompile(`/specifications/([^/]+)\.gemspec$`)
+	npmPackage = regexp.MustCompile(`/lib/node_modules/((@[^/]+/)?[^/@]+)/package\.json$`)
+)
+
+// packageDBs maps the database files of package managers with a readable
+// database to their parsers
+var packageDBs = map[string]struct {
+	manager string
+	parse   func(db string) map[string]string
+}{
+	"/var/lib/dpkg/status":  {"dpkg", parseDpkgStatus},
+	"/lib/apk/db/installed": {"apk", parseApkInstalled},
+}
+
+// Packages summarizes the package changes made by res by comparing the
+// package databases found in its changes before and after the step. The
+// rpm database can't be read directly, so rpm is only queried for
+// committed steps.
+func (w *Workspace) Packages(res EvalResult) ([]PackageChange, error) {
+	packages := []PackageChange{}
+	if res.Id == "" {
+		return packages, nil
+	}
+
+	var parent *docker.Container
+	readBefore := func(file string) ([]byte, error) {
+		if parent == nil {
+			nop, err := createNop(w.docker, res.Image)
+			if err != nil {
+				return nil, err
+			}
+			parent = nop
+		}
+		content, _, err := ReadFile(w.docker, parent.ID, file, maxPackageDBSize)
+		return content, err
+	}
+	defer func() {
+		if parent != nil {
+			RemoveContainer(w.docker, parent.ID)
+		}
+	}()
+
+	pathsBefore := map[string]map[string]string{"pip": {}, "gem": {}}
+	pathsAfter := map[string]map[string]string{"pip": {}, "gem": {}}
+	rpm := false
+	changes, _ := w.filterChanges(res.Changes)
+	for _, change := range changes {
+		if db, ok := packageDBs[change.Path]; ok {
+			before, err := readBefore(change.Path)
+			if err != nil {
+				return packages, err
+			}
+			after, _, err := ReadFile(w.docker, res.Id, change.Path, maxPackageDBSize)
+			if err != nil {
+				return packages, err
+			}
+			packages = append(packages, comparePackages(db.manager, db.parse(string(before)), db.parse(string(after)))...)
+			continue
+		}
+		if strings.HasPrefix(change.Path, "/var/lib/rpm/") {
+			rpm = true
+			continue
+		}
+		if m := npmPackage.FindStringSubmatch(change.Path); m != nil {
+			before, err := readBefore(change.Path)
+			if err != nil {
+				return packages, err
+			}
+			after, _, err := ReadFile(w.docker, res.Id, change.Path, maxPackageDBSize)
+			if err != nil {
+				return packages, err
+			}
+			packages = append(packages, comparePackages("npm",
+				map[string]string{m[1]: npmVersion(before)},
+				map[string]string{m[1]: npmVersion(after)})...)
+			continue
+		}
+		var manager, name string
+		if m := pipPackage.FindStringSubmatch(change.Path); m != nil {
+			manager, name = "pip", m[2]
+		} else if m := gemPackage.FindStringSubmatch(change.Path); m != nil {
+			manager, name = "gem", m[1]
+		} else {
+			continue
+		}
+		name, version := splitNameVersion(name)
+		switch change.Kind {
+		case 1:
+			pathsAfter[manager][name] = version
+		case 2:
+			pathsBefore[manager][name] = version
+		}
+	}
+	for _, manager := range []string{"pip", "gem"} {
+		packages = append(packages, comparePackages(manager, pathsBefore[manager], pathsAfter[manager])...)
+	}
+
+	if rpm && res.NewImage != "" {
+		before, err := rpmPackages(w.docker, res.Image)
+		if err != nil {
+			return packages, err
+		}
+		after, err := rpmPackages(w.docker, res.NewImage)
+		if err != nil {
+			return packages, err
+		}
+		packages = append(packages, comparePackages("rpm", before, after)...)
+	}
+	return packages, nil
+}
+
+// comparePackages lists the differences between the package versions in
+// before and after, sorted by name
+func comparePackages(manager string, before map[string]string, after map[string]string) []PackageChange {
+	names := []string{}
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	res := []PackageChange{}
+	for _, name := range names {
+		if before[name] != after[name] {
+			res = append(res, PackageChange{Manager: manager, Name: name, Old: before[name], New: after[name]})
+		}
+	}
+	return res
+}
+
+// parseDpkgStatus returns the installed packages from /var/lib/dpkg/status
+func parseDpkgStatus(db string) map[string]string {
+	packages := map[string]string{}
+	for _, stanza := range parseStanzas(db, ": ") {
+		if strings.HasSuffix(stanza["Status"], " installed") {
+			packages[stanza["Package"]] = stanza["Version"]
+		}
+	}
+	return packages
+}
+
+// parseApkInstalled returns the installed packages from /lib/apk/db/installed
+func parseApkInstalled(db string) map[string]string {
+	packages := map[string]string{}
+	for _, stanza := range parseStanzas(db, ":") {
+		if stanza["P"] != "" {
+			packages[stanza["P"]] = stanza["V"]
+		}
+	}
+	return packages
+}
+
+// parseStanzas splits a database of `key<sep>value` fields in blank line
+// separated stanzas. Continuation lines are ignored.
+func parseStanzas(db string, sep string) []map[string]string {
+	stanzas := []map[string]string{}
+	current := map[string]string{}
+	for _, line := range strings.Split(db, "\n") {
+		if line == "" {
+			if len(current) > 0 {
+				stanzas = append(stanzas, current)
+				current = map[string]string{}
+			}
+			continue
+		}
+		if strings.HasPrefix(line, " ") {
+			continue
+		}
+		kv := strings.SplitN(line, sep, 2)
+		if len(kv) == 2 {
+			current[kv[0]] = kv[1]
+		}
+	}
+	if len(current) > 0 {
+		stanzas = append(stanzas, current)
+	}
+	return stanzas
+}
+
+func rpmPackages(d DockerService, image string) (map[string]string, error) {
+	out, _, err := Output(d, image, []string{"rpm", "-qa", "--qf", `%{NAME} %{VERSION}-%{RELEASE}\n`})
+	if err != nil {
+		return nil, err
+	}
+	packages := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			packages[fields[0]] = fields[1]
+		}
+	}
+	return packages, nil
+}
+
+func npmVersion(content []byte) string {
+	var pkg struct {
+		Version string `json:"version"`
+	}
+	if len(content) == 0 || json.Unmarshal(content, &pkg) != nil {
+		return ""
+	}
+	return pkg.Version
+}
+
+// splitNameVersion splits name-1.0 at the last dash followed by a digit,
+// which also handles versions with a platform suffix like name-1.0-x86_64
+func splitNameVersion(s string) (string, string) {
+	for i := len(s) - 2; i > 0; i-- {
+		if s[i] == '-' && s[i+1] >= '0' && s[i+1] <= '9' {
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
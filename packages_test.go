@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+const dpkgBefore = `Package: openssl
+Status: install ok installed
+Version: 1.0.1f-1ubuntu2
+Description: Secure Sockets Layer toolkit
+ continued description
+
+Package: vim-tiny
+Status: install ok installed
+Version: 2:7.4.052-1ubuntu3
+`
+
+const dpkgAfter = `Package: openssl
+Status: install ok installed
+Version: 1.0.1f-1ubuntu2.16
+
+Package: nginx
+Status: install ok installed
+Version: 1.4.6-1ubuntu3.3
+
+Package: vim-tiny
+Status: deinstall ok config-files
+Version: 2:7.4.052-1ubuntu3
+`
+
+func TestParsePackageDBs(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(map[string]string{
+		"openssl":  "1.0.1f-1ubuntu2",
+		"vim-tiny": "2:7.4.052-1ubuntu3",
+	}, parseDpkgStatus(dpkgBefore))
+	assert.Equal(map[string]string{"musl": "1.1.11-r2", "curl": "7.47.0-r0"},
+		parseApkInstalled("C:Q1abc=\nP:musl\nV:1.1.11-r2\n\nP:curl\nV:7.47.0-r0\n"))
+}
+
+func TestSplitNameVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := [][3]string{
+		{"requests-2.9.1", "requests", "2.9.1"},
+		{"nokogiri-1.6.6.2-x86_64-linux", "nokogiri", "1.6.6.2-x86_64-linux"},
+		{"python-dateutil-2.4.2", "python-dateutil", "2.4.2"},
+		{"unversioned", "unversioned", ""},
+	}
+	for _, c := range cases {
+		name, version := splitNameVersion(c[0])
+		assert.Equal(c[1], name)
+		assert.Equal(c[2], version)
+	}
+}
+
+func TestWorkspacePackages(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	mockdock.Files["ubuntu:trusty"] = map[string]string{
+		"/var/lib/dpkg/status":                   dpkgBefore,
+		"/usr/lib/node_modules/npm/package.json": `{"name": "npm", "version": "2.14.7"}`,
+	}
+	mockdock.Files["c1"] = map[string]string{
+		"/var/lib/dpkg/status":                            dpkgAfter,
+		"/usr/lib/node_modules/npm/package.json":          `{"name": "npm", "version": "3.5.2"}`,
+		"/usr/lib/node_modules/@angular/cli/package.json": `{"name": "@angular/cli", "version": "1.0.0"}`,
+	}
+	res, _ := ws.Eval("cmd1")
+	res.Changes = []docker.Change{
+		{Path: "/var/lib/dpkg/status", Kind: 0},
+		{Path: "/usr/lib/python2.7/dist-packages/requests-2.2.1.egg-info", Kind: 2},
+		{Path: "/usr/local/lib/python2.7/dist-packages/requests-2.9.1.dist-info", Kind: 1},
+		{Path: "/usr/local/lib/python2.7/dist-packages/requests-2.9.1.dist-info/METADATA", Kind: 1},
+		{Path: "/var/lib/gems/1.9.1/specifications/bundler-1.11.2.gemspec", Kind: 1},
+		{Path: "/usr/lib/node_modules/npm/package.json", Kind: 0},
+		{Path: "/usr/lib/node_modules/@angular/cli/package.json", Kind: 1},
+		{Path: "/etc/nginx/nginx.conf", Kind: 1},
+	}
+
+	packages, err := ws.Packages(res)
+	assert.NoError(err)
+	assert.Equal([]PackageChange{
+		{Manager: "dpkg", Name: "nginx", New: "1.4.6-1ubuntu3.3"},
+		{Manager: "dpkg", Name: "openssl", Old: "1.0.1f-1ubuntu2", New: "1.0.1f-1ubuntu2.16"},
+		{Manager: "dpkg", Name: "vim-tiny", Old: "2:7.4.052-1ubuntu3"},
+		{Manager: "npm", Name: "npm", Old: "2.14.7", New: "3.5.2"},
+		{Manager: "npm", Name: "@angular/cli", New: "1.0.0"},
+		{Manager: "pip", Name: "requests", Old: "2.2.1", New: "2.9.1"},
+		{Manager: "gem", Name: "bundler", New: "1.11.2"},
+	}, packages)
+	assert.Len(mockdock.Containers, 1)
+
+	res.Changes = []docker.Change{{Path: "/etc/nginx/nginx.conf", Kind: 1}}
+	packages, err = ws.Packages(res)
+	assert.NoError(err)
+	assert.Len(packages, 0)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// stdinScript runs the arguments it is given with the command read from
+// stdin appended, so a container can be created before the command is known
+const stdinScript = `exec "$@" "$(cat)"`
+
+// containerPool keeps containers created ahead of time for the current
+// image, config and mode, so evaluations don't wait for CreateContainer.
+// Pooled containers read their command from stdin. The pool is refilled in
+// the background and drained when the containers no longer match.
+type containerPool struct {
+	d       DockerService
+	size    int
+	lock    sync.Mutex
+	key     string //options the ready containers were created with
+	options docker.CreateContainerOptions
+	ready   []string //IDs of containers that can be taken
+	filling bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func newContainerPool(d DockerService, size int) *containerPool {
+	return &containerPool{d: d, size: size}
+}
+
+// pooledContainer converts the options of an Eval container running command
+// into options for a container reading it from stdin. Only modes passing
+// the command as the last argument can be pooled.
+func pooledContainer(command string, options docker.CreateContainerOptions) (docker.CreateContainerOptions, bool) {
+	config := *options.Config
+	args := append(append([]string{}, config.Entrypoint...), config.Cmd...)
+	if len(args) < 2 || args[len(args)-1] != command {
+		return options, false
+	}
+	run := append([]string{"-c", stdinScript, "sh"}, args[:len(args)-1]...)
+	if len(config.Entrypoint) > 0 {
+		config.Entrypoint = []string{"/bin/sh"}
+		config.Cmd = run
+	} else {
+		config.Cmd = append([]string{"/bin/sh"}, run...)
+	}
+	config.AttachStdin = true
+	config.OpenStdin = true
+	config.StdinOnce = true
+	options.Config = &config
+	return options, true
+}
+
+// prepare makes the pool hold containers created with options, removing
+// the ones that don't match, and refills it in the background
+func (p *containerPool) prepare(options docker.CreateContainerOptions) {
+	if p == nil {
+		return
+	}
+	key := poolKey(options)
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	var stale []string
+	if key != p.key {
+		stale, p.ready = p.ready, nil
+		p.key, p.options = key, options
+	}
+	p.wg.Add(1)
+	p.lock.Unlock()
+
+	go func() {
+		defer p.wg.Done()
+		p.remove(stale)
+		p.fill()
+	}()
+}
+
+// take returns a ready container created with options, if there is one
+func (p *containerPool) take(options docker.CreateContainerOptions) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	p.prepare(options)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.ready) == 0 {
+		return "", false
+	}
+	id := p.ready[0]
+	p.ready = p.ready[1:]
+	return id, true
+}
+
+// invalidate removes the ready containers, after a commit or when the
+// image changes
+func (p *containerPool) invalidate() {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	stale := p.ready
+	p.ready, p.key = nil, ""
+	p.lock.Unlock()
+	p.remove(stale)
+}
+
+// close stops refilling and removes all pooled containers
+func (p *containerPool) close() {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	p.closed = true
+	p.lock.Unlock()
+	p.wg.Wait()
+	p.invalidate()
+}
+
+// fill creates containers until the pool is full. Only one fill runs at a
+// time; containers created for options that changed meanwhile are removed.
+func (p *containerPool) fill() {
+	p.lock.Lock()
+	if p.filling {
+		p.lock.Unlock()
+		return
+	}
+	p.filling = true
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		p.filling = false
+		p.lock.Unlock()
+	}()
+
+	for {
+		p.lock.Lock()
+		key, options := p.key, p.options
+		done := p.closed || key == "" || len(p.ready) >= p.size
+		p.lock.Unlock()
+		if done {
+			return
+		}
+		cont, err := p.d.CreateContainer(options)
+		if err != nil {
+			return
+		}
+		p.lock.Lock()
+		if p.key == key && !p.closed {
+			p.ready = append(p.ready, cont.ID)
+			cont = nil
+		}
+		p.lock.Unlock()
+		if cont != nil {
+			RemoveContainer(p.d, cont.ID)
+		}
+	}
+}
+
+func (p *containerPool) remove(ids []string) {
+	for _, id := range ids {
+		RemoveContainer(p.d, id)
+	}
+}
+
+func poolKey(options docker.CreateContainerOptions) string {
+	data, _ := json.Marshal(options)
+	return string(data)
+}
+
+// warm prepares the pool for the current image, config and mode
+func (w *Workspace) warm() {
+	if w.pool == nil {
+		return
+	}
+	const command = "#(nop) cyclops"
+	if options, ok := pooledContainer(command, evalContainer(command, w.CurrentImage, w.evalOptions(command))); ok {
+		w.pool.prepare(options)
+	} else {
+		w.pool.invalidate()
+	}
+}
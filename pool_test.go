@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPooledContainer(t *testing.T) {
+	assert := assert.New(t)
+	options := docker.CreateContainerOptions{
+		Config: &docker.Config{Image: "ubuntu:trusty", Cmd: []string{"/bin/bash", "-c", "ls"}},
+	}
+	pooled, ok := pooledContainer("ls", options)
+	assert.True(ok)
+	assert.Equal([]string{"/bin/sh", "-c", stdinScript, "sh", "/bin/bash", "-c"}, pooled.Config.Cmd)
+	assert.True(pooled.Config.OpenStdin)
+	assert.True(pooled.Config.StdinOnce)
+	assert.Equal([]string{"/bin/bash", "-c", "ls"}, options.Config.Cmd)
+
+	options.Config.Entrypoint = []string{"/entrypoint.sh"}
+	pooled, ok = pooledContainer("ls", options)
+	assert.True(ok)
+	assert.Equal([]string{"/bin/sh"}, pooled.Config.Entrypoint)
+	assert.Equal([]string{"-c", stdinScript, "sh", "/entrypoint.sh", "/bin/bash", "-c"}, pooled.Config.Cmd)
+
+	// the command isn't the last argument, e.g. when written to a file first
+	options.Config.Entrypoint = nil
+	options.Config.Cmd = []string{"sh", "-c", "ls", "sh"}
+	_, ok = pooledContainer("ls", options)
+	assert.False(ok)
+}
+
+func TestContainerPool(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	pool := newContainerPool(mockdock, 2)
+	trusty := docker.CreateContainerOptions{Config: &docker.Config{Image: "ubuntu:trusty"}}
+	xenial := docker.CreateContainerOptions{Config: &docker.Config{Image: "ubuntu:xenial"}}
+
+	pool.prepare(trusty)
+	pool.wg.Wait()
+	assert.Len(mockdock.Created, 2)
+
+	id, ok := pool.take(trusty)
+	assert.True(ok)
+	assert.Equal("c1", id)
+	pool.wg.Wait()
+	assert.Len(mockdock.Created, 3)
+
+	// containers for other options are replaced
+	id, ok = pool.take(xenial)
+	assert.False(ok)
+	pool.wg.Wait()
+	assert.Len(mockdock.Created, 5)
+	assert.Equal([]string{"c4", "c5"}, pool.ready)
+
+	pool.close()
+	assert.Empty(pool.ready)
+	pool.prepare(trusty)
+	pool.wg.Wait()
+	assert.Len(mockdock.Created, 5)
+
+	// a nil pool is disabled
+	var disabled *containerPool
+	disabled.prepare(trusty)
+	_, ok = disabled.take(trusty)
+	assert.False(ok)
+	disabled.close()
+}
+
+func TestEvalPooled(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	ws.pool = newContainerPool(mockdock, 1)
+	ws.warm()
+	ws.pool.wg.Wait()
+	assert.Len(mockdock.Created, 1)
+
+	res, err := ws.Eval("ls")
+	assert.NoError(err)
+	assert.Equal("c1", res.Id)
+	ws.pool.wg.Wait()
+	assert.Len(mockdock.Created, 2)
+	assert.Equal("/bin/sh", mockdock.Created[0].Config.Cmd[0])
+
+	// committing moves the pool to the new image
+	res, err = ws.Run("apt-get update")
+	assert.NoError(err)
+	assert.Equal("c2", res.Id)
+	ws.pool.wg.Wait()
+	assert.Equal("i2", mockdock.Created[len(mockdock.Created)-1].Config.Image)
+	assert.Len(ws.pool.ready, 1)
+
+	ws.back(1)
+	ws.pool.wg.Wait()
+	assert.Equal("ubuntu:trusty", mockdock.Created[len(mockdock.Created)-1].Config.Image)
+
+	ws.pool.close()
+	assert.Empty(ws.pool.ready)
+}
+
+func TestEvalPooledStartFailure(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	mockdock.Unstartable["c1"] = true
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	ws.pool = newContainerPool(mockdock, 1)
+	ws.warm()
+	ws.pool.wg.Wait()
+
+	// the command runs in a new container instead
+	res, err := ws.Eval("ls")
+	assert.NoError(err)
+	assert.NotEqual("c1", res.Id)
+	_, err = mockdock.InspectContainer("c1")
+	assert.Error(err)
+	ws.pool.close()
+}
+
+func TestRunPooledCmd(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	mockdock.Configs["ubuntu:trusty"] = &docker.Config{Cmd: []string{"/bin/bash"}}
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	ws.pool = newContainerPool(mockdock, 1)
+	ws.warm()
+	ws.pool.wg.Wait()
+
+	// the image keeps the command of its base, not the one of the pool
+	_, err := ws.Run("apt-get update")
+	assert.NoError(err)
+	assert.Equal([]string{"/bin/bash"}, mockdock.Committed[0].Run.Cmd)
+
+	ws.Directive("CMD", "nginx")
+	_, err = ws.Run("apt-get install -y nginx")
+	assert.NoError(err)
+	assert.Equal([]string{"/bin/sh", "-c", "nginx"}, mockdock.Committed[1].Run.Cmd)
+	ws.pool.close()
+}
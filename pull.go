@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// pull policies for base images
+const (
+	pullAlways  = "always"
+	pullMissing = "missing"
+	pullNever   = "never"
+)
+
+// dockerHubAuth is the key of Docker Hub credentials in the docker config
+const dockerHubAuth = "https://index.docker.io/v1/"
+
+var ErrInvalidPullPolicy = errors.New("Pull policy must be always, missing or never")
+
+// ensureImage makes sure image exists locally, pulling it according to
+// the pull policy of the workspace
+func (w *Workspace) ensureImage(image string) error {
+	switch w.Pull {
+	case pullAlways:
+	case pullNever:
+		return verifyImage(w.docker, image)
+	case pullMissing, "":
+		err := verifyImage(w.docker, image)
+		if err != docker.ErrNoSuchImage {
+			return err
+		}
+	default:
+		return ErrInvalidPullPolicy
+	}
+	fmt.Println("Pulling", image)
+	return PullImage(w.docker, image, w.auths[imageRegistry(image)], w.pullOutput)
+}
+
+// PullImage pulls image, rendering the progress of each layer to out.
+// Errors reported in the progress stream are returned.
+func PullImage(d DockerService, image string, auth docker.AuthConfiguration, out io.Writer) error {
+	repository, tag := parseImageName(image)
+	r, w := io.Pipe()
+	opts := docker.PullImageOptions{
+		Repository:    repository,
+		Tag:           tag,
+		OutputStream:  w,
+		RawJSONStream: true,
+	}
+	go func() {
+		w.CloseWithError(d.PullImage(opts, auth))
+	}()
+
+	progress := newPullProgress(out)
+	dec := json.NewDecoder(r)
+	for {
+		var msg pullMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			r.CloseWithError(err)
+			return err
+		}
+		if msg.Error != "" {
+			r.CloseWithError(errors.New(msg.Error))
+			return errors.New(msg.Error)
+		}
+		progress.update(msg)
+	}
+	return nil
+}
+
+// parseImageName splits image into repository and tag, which defaults to
+// latest. Images by digest are passed as the repository.
+func parseImageName(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// imageRegistry returns the host of the registry image is pulled from, as
+// used to look up credentials
+func imageRegistry(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return registryHost(dockerHubAuth)
+}
+
+// registryHost normalizes a registry address of the docker config, which
+// may be a URL, to its host
+func registryHost(address string) string {
+	if strings.Contains(address, "://") {
+		if u, err := url.Parse(address); err == nil {
+			address = u.Host
+		}
+	}
+	address = strings.SplitN(address, "/", 2)[0]
+	if address == "registry-1.docker.io" || address == "docker.io" {
+		return "index.docker.io"
+	}
+	return address
+}
+
+// dockerAuths returns the credentials of the docker config by registry
+// host. Credential helpers are not supported.
+func dockerAuths(config dockerConfig) (map[string]docker.AuthConfiguration, error) {
+	auths := map[string]docker.AuthConfiguration{}
+	for address, auth := range config.Auths {
+		if auth.Auth == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credentials for %s: %v", address, err)
+		}
+		userPass := strings.SplitN(string(decoded), ":", 2)
+		if len(userPass) != 2 {
+			return nil, fmt.Errorf("invalid credentials for %s", address)
+		}
+		auths[registryHost(address)] = docker.AuthConfiguration{
+			Username:      userPass[0],
+			Password:      userPass[1],
+			Email:         auth.Email,
+			ServerAddress: address,
+		}
+	}
+	return auths, nil
+}
+
+// pullMessage is a message of the JSON progress stream of a pull
+type pullMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Progress       string `json:"progress"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+}
+
+// pullProgress renders a line per layer. On a terminal the lines are
+// redrawn in place as progress is made; otherwise only changes of status
+// are printed.
+type pullProgress struct {
+	out      io.Writer
+	terminal bool
+	layers   []string          //layer IDs drawn since the last message without ID
+	lines    map[string]string //layer ID to its current line
+	status   map[string]string //layer ID to its current status
+}
+
+func newPullProgress(out io.Writer) *pullProgress {
+	p := &pullProgress{out: out, lines: map[string]string{}, status: map[string]string{}}
+	if f, ok := out.(*os.File); ok {
+		p.terminal = isTerminal(f)
+	}
+	return p
+}
+
+func (p *pullProgress) update(msg pullMessage) {
+	if msg.ID == "" {
+		fmt.Fprintln(p.out, msg.Status)
+		p.layers = nil
+		return
+	}
+	changed := p.status[msg.ID] != msg.Status
+	p.status[msg.ID] = msg.Status
+	if !p.terminal {
+		if changed {
+			fmt.Fprintln(p.out, msg.ID+": "+msg.Status)
+		}
+		return
+	}
+
+	line := msg.ID + ": " + msg.Status
+	if msg.Progress != "" {
+		line += " " + msg.Progress
+	} else if msg.ProgressDetail.Total > 0 {
+		line += fmt.Sprintf(" %d/%d", msg.ProgressDetail.Current, msg.ProgressDetail.Total)
+	}
+	p.lines[msg.ID] = line
+
+	// move up to the first layer line and redraw all of them
+	if len(p.layers) > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA", len(p.layers))
+	}
+	if !containsString(p.layers, msg.ID) {
+		p.layers = append(p.layers, msg.ID)
+	}
+	for _, id := range p.layers {
+		fmt.Fprintf(p.out, "%s\x1b[K\n", p.lines[id])
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetImagePull(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	out := new(bytes.Buffer)
+	ws.pullOutput = out
+
+	assert.NoError(ws.SetImage("ubuntu:trusty"))
+	assert.Len(mockdock.Pulled, 0)
+
+	mockdock.MissingImages = map[string]bool{"debian:jessie": true}
+	ws.Pull = pullNever
+	assert.Error(ws.SetImage("debian:jessie"))
+	assert.Equal("ubuntu:trusty", ws.Image)
+
+	ws.Pull = pullMissing
+	assert.NoError(ws.SetImage("debian:jessie"))
+	assert.Equal([]string{"debian:jessie"}, mockdock.Pulled)
+	assert.Equal("debian:jessie", ws.Image)
+	assert.Equal("Pulling from debian\nabc123: Downloading\nabc123: Pull complete\n", out.String())
+
+	ws.Pull = pullAlways
+	assert.NoError(ws.SetImage("debian:jessie"))
+	assert.Len(mockdock.Pulled, 2)
+
+	mockdock.FailPull = true
+	assert.EqualError(ws.SetImage("centos:7"), "MOCK: Failed to pull image")
+
+	ws.Pull = "sometimes"
+	assert.Equal(ErrInvalidPullPolicy, ws.SetImage("centos:7"))
+}
+
+func TestParseImageName(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		Image, Repository, Tag string
+	}{
+		{"ubuntu", "ubuntu", "latest"},
+		{"ubuntu:trusty", "ubuntu", "trusty"},
+		{"localhost:5000/app", "localhost:5000/app", "latest"},
+		{"localhost:5000/app:1.0", "localhost:5000/app", "1.0"},
+		{"alpine@sha256:abc", "alpine@sha256:abc", ""},
+	}
+	for _, c := range cases {
+		repository, tag := parseImageName(c.Image)
+		assert.Equal(c.Repository, repository, c.Image)
+		assert.Equal(c.Tag, tag, c.Image)
+	}
+}
+
+func TestDockerAuths(t *testing.T) {
+	assert := assert.New(t)
+
+	var config dockerConfig
+	assert.NoError(json.Unmarshal([]byte(`{"auths":{
+		"https://index.docker.io/v1/":{"auth":"dXNlcjpwYXNz"},
+		"registry.example.com":{"auth":"Ym90OnM6ZWNyZXQ=","email":"bot@example.com"},
+		"helper.example.com":{}
+	}}`), &config))
+	auths, err := dockerAuths(config)
+	assert.NoError(err)
+	assert.Len(auths, 2)
+
+	hub := auths[imageRegistry("ubuntu:trusty")]
+	assert.Equal("user", hub.Username)
+	assert.Equal("pass", hub.Password)
+	private := auths[imageRegistry("registry.example.com/team/app:1.0")]
+	assert.Equal("bot", private.Username)
+	assert.Equal("s:ecret", private.Password)
+	assert.Equal("registry.example.com", private.ServerAddress)
+}
+
+func TestPullProgressTerminal(t *testing.T) {
+	assert := assert.New(t)
+	out := new(bytes.Buffer)
+	p := &pullProgress{out: out, terminal: true, lines: map[string]string{}, status: map[string]string{}}
+
+	p.update(pullMessage{ID: "a", Status: "Waiting"})
+	p.update(pullMessage{ID: "b", Status: "Downloading", Progress: "[=>   ]"})
+	p.update(pullMessage{ID: "a", Status: "Pull complete"})
+	assert.Equal("a: Waiting\x1b[K\n"+
+		"\x1b[1Aa: Waiting\x1b[K\nb: Downloading [=>   ]\x1b[K\n"+
+		"\x1b[2Aa: Pull complete\x1b[K\nb: Downloading [=>   ]\x1b[K\n", out.String())
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Transcript is a recording of the commands entered in a session and their
+// results, replayed with `cyclops replay` to detect drift
+type Transcript struct {
+	Image   string           `json:"image"` //image the recording started from
+	Mode    string           `json:"mode"`
+	Runtime RuntimeOptions   `json:"runtime"`
+	Ignore  []string         `json:"ignore"` //ignore patterns the changes were filtered with
+	Steps   []TranscriptStep `json:"steps"`
+}
+
+// TranscriptStep is a command of a transcript, with the step it ran if any
+type TranscriptStep struct {
+	Command string      `json:"command"`
+	Args    string      `json:"args,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Result  *EvalResult `json:"result,omitempty"`
+}
+
+// Input formats the step the way it was entered
+func (s TranscriptStep) Input() string {
+	if s.Command == "eval" {
+		return s.Args
+	}
+	if s.Args == "" {
+		return ":" + s.Command
+	}
+	return ":" + s.Command + " " + s.Args
+}
+
+// Drift is a difference between a recorded step and its replay
+type Drift struct {
+	Step  int    //number of the step in the transcript, from 1
+	Input string //the step as entered
+	What  string //what drifted: error, exit code, output or changes
+	Lines []string
+}
+
+// newTranscript starts a transcript from the current state of w
+func newTranscript(w *Workspace) *Transcript {
+	return &Transcript{
+		Image:   w.CurrentImage,
+		Mode:    w.Mode,
+		Runtime: w.Runtime,
+		Ignore:  append([]string{}, w.ignore...),
+		Steps:   []TranscriptStep{},
+	}
+}
+
+func loadTranscript(path string) (*Transcript, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Transcript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	if t.Image == "" {
+		return nil, errors.New("Transcript has no image")
+	}
+	return &t, nil
+}
+
+func (t *Transcript) Save(path string) error {
+	out, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// apply sets up w to replay the transcript
+func (t *Transcript) apply(w *Workspace) error {
+	if err := w.SetImage(t.Image); err != nil {
+		return err
+	}
+	if err := w.SetMode(t.Mode); err != nil {
+		return err
+	}
+	w.Runtime = t.Runtime
+	w.ignore = append([]string{}, t.Ignore...)
+	return nil
+}
+
+func (t *Transcript) add(report *commandReport) {
+	t.Steps = append(t.Steps, TranscriptStep{
+		Command: report.Command,
+		Args:    report.Args,
+		Error:   report.Error,
+		Result:  report.Result,
+	})
+}
+
+// compareSteps lists how actual drifted from the recorded step expected.
+// Container and image IDs and durations are expected to change and aren't
+// compared.
+func compareSteps(n int, expected TranscriptStep, actual TranscriptStep) []Drift {
+	drifts := []Drift{}
+	drift := func(what string, lines ...string) {
+		drifts = append(drifts, Drift{Step: n, Input: expected.Input(), What: what, Lines: lines})
+	}
+	if expected.Error != actual.Error {
+		drift("error", fmt.Sprintf("expected %q, got %q", expected.Error, actual.Error))
+	}
+	if expected.Result == nil || actual.Result == nil {
+		return drifts
+	}
+	if expected.Result.Code != actual.Result.Code {
+		drift("exit code", fmt.Sprintf("expected %d, got %d", expected.Result.Code, actual.Result.Code))
+	}
+	if lines := unifiedDiff(splitLines(logBytes(expected.Result)), splitLines(logBytes(actual.Result)), "recorded", "replayed"); len(lines) > 0 {
+		drift("output", lines...)
+	}
+	if lines := compareChanges(expected.Result, actual.Result); len(lines) > 0 {
+		drift("changes", lines...)
+	}
+	return drifts
+}
+
+func logBytes(res *EvalResult) []byte {
+	if res.Log == nil {
+		return nil
+	}
+	return res.Log.Bytes()
+}
+
+// compareChanges lists the changes only made by one of the steps, prefixed
+// with - when missing from actual and + when new
+func compareChanges(expected *EvalResult, actual *EvalResult) []string {
+	format := func(res *EvalResult) map[string]bool {
+		changes := map[string]bool{}
+		for _, change := range res.Changes {
+			changes[fmt.Sprintf("%s (%s)", change.Path, changeKinds[change.Kind])] = true
+		}
+		return changes
+	}
+	before, after := format(expected), format(actual)
+	lines := []string{}
+	for change := range before {
+		if !after[change] {
+			lines = append(lines, "- "+change)
+		}
+	}
+	for change := range after {
+		if !before[change] {
+			lines = append(lines, "+ "+change)
+		}
+	}
+	sort.Sort(byPath(lines))
+	return lines
+}
+
+var changeKinds = map[docker.ChangeType]string{0: "modified", 1: "added", 2: "deleted"}
+
+// byPath sorts compareChanges lines by path, ignoring the prefix
+type byPath []string
+
+func (s byPath) Len() int           { return len(s) }
+func (s byPath) Less(i, j int) bool { return s[i][2:] < s[j][2:] }
+func (s byPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// record adds the report of a top level command to the transcript being
+// recorded and saves it, so the transcript survives a crash
+func (c *cli) record(report *commandReport) {
+	c.transcript.add(report)
+	if c.transcriptPath == "" {
+		return
+	}
+	if err := c.transcript.Save(c.transcriptPath); err != nil {
+		fmt.Println("error saving transcript:", err)
+	}
+}
+
+// replay executes the steps of a transcript, which must have been applied
+// to the workspace, and reports the steps that drifted. Interactive shell
+// sessions can't be replayed and are skipped, and the cache isn't used.
+func (c *cli) replay(t *Transcript) int {
+	// reusing cached images would hide the drift
+	c.ws.NoCache = true
+	c.transcript = newTranscript(c.ws)
+	c.transcriptPath = ""
+	drifts := []Drift{}
+	for i, step := range t.Steps {
+		fmt.Printf("%s> %s\n", defaultPrompt, step.Input())
+		if step.Command == "shell" {
+			fmt.Println("Skipped: interactive shell sessions can't be replayed")
+			continue
+		}
+		if err := c.execute(step.Command, step.Args); err == ErrQuit {
+			break
+		}
+		actual := c.transcript.Steps[len(c.transcript.Steps)-1]
+		drifts = append(drifts, compareSteps(i+1, step, actual)...)
+	}
+	c.transcript = nil
+	printDrifts(len(t.Steps), drifts)
+	if len(drifts) > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
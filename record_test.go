@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReplay(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "cyclops")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "transcript.json")
+
+	mockdock := NewMockDockerClient()
+	mockdock.Changes = []docker.Change{{Path: "/usr/sbin/nginx", Kind: 1}}
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	c := newCli(ws, "")
+	c.execute("mode", "sh")
+	c.execute("record", path)
+	c.execute("run", "apt-get install -y nginx")
+	c.execute("env", "FOO=bar")
+	c.execute("quit", "")
+	c.execute("record", "")
+	c.execute("run", "not recorded")
+
+	transcript, err := loadTranscript(path)
+	assert.NoError(err)
+	assert.Equal("ubuntu:trusty", transcript.Image)
+	assert.Equal("sh", transcript.Mode)
+	assert.Len(transcript.Steps, 2)
+	assert.Equal(":run apt-get install -y nginx", transcript.Steps[0].Input())
+	assert.Equal(1, len(transcript.Steps[0].Result.Changes))
+
+	replay := func(mockdock *MockDockerClient) int {
+		ws := NewWorkspace(mockdock, "bash", "alpine")
+		assert.NoError(transcript.apply(ws))
+		assert.Equal("sh", ws.Mode)
+		return newCli(ws, "").replay(transcript)
+	}
+	assert.Equal(exitOK, replay(mockdock))
+
+	drifted := NewMockDockerClient()
+	drifted.PleaseReturn = 100
+	assert.Equal(exitFailure, replay(drifted))
+}
+
+func TestCompareSteps(t *testing.T) {
+	assert := assert.New(t)
+	log := func(s string) *Buffer {
+		b := NewBuffer(ioutil.Discard)
+		b.WriteString(s)
+		return b
+	}
+	expected := TranscriptStep{Command: "run", Args: "make", Result: &EvalResult{
+		Log:     log("ok\n"),
+		Changes: []docker.Change{{Path: "/usr/bin/make", Kind: 1}, {Path: "/etc/motd", Kind: 0}},
+	}}
+	assert.Len(compareSteps(1, expected, expected), 0)
+
+	actual := TranscriptStep{Command: "run", Args: "make", Error: ErrStepFailed.Error(), Result: &EvalResult{
+		Code:    2,
+		Log:     log("no rule\n"),
+		Changes: []docker.Change{{Path: "/usr/bin/make", Kind: 1}, {Path: "/etc/issue", Kind: 0}},
+	}}
+	drifts := compareSteps(3, expected, actual)
+	assert.Len(drifts, 4)
+	assert.Equal(3, drifts[0].Step)
+	assert.Equal(":run make", drifts[0].Input)
+	assert.Equal("error", drifts[0].What)
+	assert.Equal([]string{"expected 0, got 2"}, drifts[1].Lines)
+	assert.Equal([]string{"--- recorded", "+++ replayed", "@@ -1,1 +1,1 @@", "-ok", "+no rule"}, drifts[2].Lines)
+	assert.Equal([]string{"+ /etc/issue (modified)", "- /etc/motd (modified)"}, drifts[3].Lines)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// commandReport is the JSON object written for each command with
+// --output=json. Human readable output goes to stderr in that mode.
+type commandReport struct {
+	Command  string          `json:"command"`            //command name without the colon, e.g. run
+	Args     string          `json:"args,omitempty"`     //arguments as given
+	Error    string          `json:"error,omitempty"`    //set when the command failed
+	Image    string          `json:"image"`              //current image after the command
+	Result   *EvalResult     `json:"result,omitempty"`   //step run by eval, run and copy, without ignored changes
+	Packages []PackageChange `json:"packages,omitempty"` //package changes of the step
+	History  []EvalResult    `json:"history,omitempty"`  //history, for :history
+	Lines    []string        `json:"lines,omitempty"`    //rendered output of :print and :diff
+	Matrix   []MatrixResult  `json:"matrix,omitempty"`   //result on each image, for :matrix
+	Services []Service       `json:"services,omitempty"` //running services, for :service list
+	Removed  []string        `json:"removed,omitempty"`  //containers removed on exit
+}
+
+// reporter writes a commandReport per line
+type reporter struct {
+	enc *json.Encoder
+}
+
+func newReporter(w io.Writer) *reporter {
+	return &reporter{enc: json.NewEncoder(w)}
+}
+
+func (r *reporter) emit(report *commandReport) {
+	if r == nil {
+		return
+	}
+	r.enc.Encode(report)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var ErrInvalidOption = errors.New("Invalid option")
+
+// RuntimeOptions configure the containers commands are evaluated in. Unlike
+// directives they are not committed to the image or written to the Dockerfile.
+type RuntimeOptions struct {
+	Binds      []string // extra host:container[:ro] mounts
+	Env        []string // KEY=value, overriding ENV directives
+	Network    string   // bridge (default), none or host
+	User       string   // overrides USER directives
+	Memory     int64    // memory limit in bytes
+	CPUShares  int64    // relative CPU weight
+	Privileged bool
+	CapAdd     []string
+	CapDrop    []string
+	Timeout    time.Duration // commands running longer are stopped
+}
+
+// runtimeOptions are the option names accepted by Set, in display order
+var runtimeOptions = []string{"bind", "env", "network", "user", "memory", "cpu-shares", "privileged", "cap-add", "cap-drop", "timeout"}
+
+// Set changes the option key. Options holding a list, like bind and env,
+// are appended to. An empty value resets the option.
+func (o *RuntimeOptions) Set(key string, value string) error {
+	value = strings.TrimSpace(value)
+	switch key {
+	case "bind":
+		if value != "" && !strings.Contains(value, ":") {
+			return fmt.Errorf("bind must be host:container, got %s", value)
+		}
+		o.Binds = appendOption(o.Binds, value)
+	case "env":
+		if value != "" && !strings.Contains(value, "=") {
+			return fmt.Errorf("env must be KEY=value, got %s", value)
+		}
+		o.Env = appendOption(o.Env, value)
+	case "network":
+		switch value {
+		case "", "bridge", "none", "host":
+			o.Network = value
+		default:
+			return fmt.Errorf("network must be bridge, none or host, got %s", value)
+		}
+	case "user":
+		o.User = value
+	case "memory":
+		memory, err := parseBytes(value)
+		if err != nil {
+			return err
+		}
+		o.Memory = memory
+	case "cpu-shares":
+		var shares int64
+		if value != "" {
+			var err error
+			if shares, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return err
+			}
+		}
+		o.CPUShares = shares
+	case "privileged":
+		if value == "" {
+			o.Privileged = false
+			return nil
+		}
+		privileged, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		o.Privileged = privileged
+	case "cap-add":
+		o.CapAdd = appendOption(o.CapAdd, value)
+	case "cap-drop":
+		o.CapDrop = appendOption(o.CapDrop, value)
+	case "timeout":
+		var timeout time.Duration
+		if value != "" {
+			var err error
+			if timeout, err = time.ParseDuration(value); err != nil {
+				return err
+			}
+		}
+		o.Timeout = timeout
+	default:
+		return ErrInvalidOption
+	}
+	return nil
+}
+
+// appendOption adds value to a list option unless it is already there.
+// An empty value clears the list.
+func appendOption(list []string, value string) []string {
+	if value == "" {
+		return nil
+	}
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// Lines formats the options that differ from the docker defaults for display
+func (o RuntimeOptions) Lines() []string {
+	res := []string{}
+	add := func(key string, values ...string) {
+		for _, value := range values {
+			res = append(res, fmt.Sprintf("%-11s %s", key+":", value))
+		}
+	}
+	add("bind", o.Binds...)
+	add("env", o.Env...)
+	if o.Network != "" {
+		add("network", o.Network)
+	}
+	if o.User != "" {
+		add("user", o.User)
+	}
+	if o.Memory != 0 {
+		add("memory", strconv.FormatInt(o.Memory, 10))
+	}
+	if o.CPUShares != 0 {
+		add("cpu-shares", strconv.FormatInt(o.CPUShares, 10))
+	}
+	if o.Privileged {
+		add("privileged", "true")
+	}
+	add("cap-add", o.CapAdd...)
+	add("cap-drop", o.CapDrop...)
+	if o.Timeout != 0 {
+		add("timeout", o.Timeout.String())
+	}
+	return res
+}
+
+// apply sets the options on the config and host config of a container
+func (o RuntimeOptions) apply(config *docker.Config, host *docker.HostConfig) {
+	for _, e := range o.Env {
+		kv := strings.SplitN(e, "=", 2)
+		config.Env = setEnv(config.Env, kv[0], kv[1])
+	}
+	if o.User != "" {
+		config.User = o.User
+	}
+	config.Memory = o.Memory
+	config.CPUShares = o.CPUShares
+	config.NetworkDisabled = o.Network == "none"
+	host.Binds = append(host.Binds, o.Binds...)
+	host.NetworkMode = o.Network
+	host.Privileged = o.Privileged
+	host.CapAdd = o.CapAdd
+	host.CapDrop = o.CapDrop
+}
+
+// parseBytes parses sizes like 512m or 2g, in bytes when no unit is given
+func parseBytes(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	units := map[byte]int64{'b': 1, 'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}
+	multiplier := int64(1)
+	last := strings.ToLower(value)[len(value)-1]
+	if unit, ok := units[last]; ok {
+		multiplier = unit
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", value)
+	}
+	return n * multiplier, nil
+}
+
+// runtimeFlag sets a runtime option from the command line. Options are
+// collected and applied once the workspace exists, over a loaded session.
+type runtimeFlag struct {
+	key     string
+	options *[][2]string
+}
+
+func (f runtimeFlag) String() string {
+	return ""
+}
+
+func (f runtimeFlag) Set(value string) error {
+	if err := new(RuntimeOptions).Set(f.key, value); err != nil {
+		return err
+	}
+	*f.options = append(*f.options, [2]string{f.key, value})
+	return nil
+}
+
+func (f runtimeFlag) IsBoolFlag() bool {
+	return f.key == "privileged"
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeOptionsSet(t *testing.T) {
+	assert := assert.New(t)
+	opts := RuntimeOptions{}
+
+	assert.NoError(opts.Set("bind", "/srv/cache:/var/cache/apt"))
+	assert.NoError(opts.Set("bind", "/srv/cache:/var/cache/apt"))
+	assert.NoError(opts.Set("env", "http_proxy=http://proxy:3128"))
+	assert.NoError(opts.Set("network", "none"))
+	assert.NoError(opts.Set("memory", "512m"))
+	assert.NoError(opts.Set("privileged", "true"))
+	assert.NoError(opts.Set("cap-add", "NET_ADMIN"))
+	assert.Equal([]string{
+		"bind:       /srv/cache:/var/cache/apt",
+		"env:        http_proxy=http://proxy:3128",
+		"network:    none",
+		"memory:     536870912",
+		"privileged: true",
+		"cap-add:    NET_ADMIN",
+	}, opts.Lines())
+
+	assert.Error(opts.Set("bind", "/srv/cache"))
+	assert.Error(opts.Set("network", "overlay"))
+	assert.Error(opts.Set("memory", "lots"))
+	assert.Equal(ErrInvalidOption, opts.Set("dns", "8.8.8.8"))
+
+	assert.NoError(opts.Set("bind", ""))
+	assert.NoError(opts.Set("privileged", ""))
+	assert.Nil(opts.Binds)
+	assert.False(opts.Privileged)
+}
+
+func TestParseBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	for value, expected := range map[string]int64{"": 0, "1024": 1024, "64k": 64 << 10, "2G": 2 << 30} {
+		n, err := parseBytes(value)
+		assert.NoError(err)
+		assert.Equal(expected, n)
+	}
+	_, err := parseBytes("-1m")
+	assert.Error(err)
+}
+
+func TestWorkflowRuntime(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	ws.Directive("ENV", "FOO=bar")
+	ws.Directive("USER", "nobody")
+	ws.Runtime.Set("env", "FOO=baz")
+	ws.Runtime.Set("user", "root")
+	ws.Runtime.Set("network", "none")
+	ws.Runtime.Set("bind", "/srv:/srv:ro")
+	ws.Run("cmd1")
+
+	created := mockdock.Created[0]
+	assert.Equal([]string{"FOO=baz"}, created.Config.Env)
+	assert.Equal("root", created.Config.User)
+	assert.True(created.Config.NetworkDisabled)
+	assert.Equal("none", created.HostConfig.NetworkMode)
+	assert.Len(created.HostConfig.Binds, 2)
+	assert.Equal("/srv:/srv:ro", created.HostConfig.Binds[1])
+
+	// the image keeps the config of the directives
+	assert.Equal(&docker.Config{Env: []string{"FOO=bar"}, User: "nobody"}, ws.Config())
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var ErrInvalidFormat = errors.New("Invalid format")
+
+// formats are the output targets for :print and :write besides the format
+// of the session mode
+var formats = map[string]func(base string, history []EvalResult) []string{
+	"dockerfile": shellMode{"/bin/bash"}.Render,
+	"sh":         renderShellScript,
+}
+
+const shellScriptHeader = `set -euo pipefail
+
+CYCLOPS_CONTEXT="$(cd "$(dirname "$0")" && pwd)"
+CYCLOPS_STATE="${CYCLOPS_STATE:-/var/lib/cyclops/$(basename "$0" .sh)}"
+mkdir -p "$CYCLOPS_STATE"`
+
+// renderShellScript renders the history as a bash provisioning script.
+// Each step is guarded by a marker file in $CYCLOPS_STATE so the script can
+// be re-run safely; ENV and WORKDIR become exports and cd.
+func renderShellScript(base string, history []EvalResult) []string {
+	res := []string{
+		"#!/bin/bash",
+		"# Generated by cyclops",
+		"# Validated against docker image: " + base,
+	}
+	res = append(res, strings.Split(shellScriptHeader, "\n")...)
+
+	config := &docker.Config{}
+	for n, entry := range history {
+		res = append(res, "")
+		switch {
+		case entry.Shell:
+			res = append(res, entry.Instruction())
+		case entry.Assert != "":
+			res = append(res, "# "+entry.Label())
+			if test := assertTest(entry); test != "" {
+				res = append(res, test)
+			}
+		case entry.Directive == "":
+			var command string
+			if _, ok := modeFor(entry.Mode).(shellMode); ok {
+				command = entry.Command
+			} else {
+				command = shellJoin(modeFor(entry.Mode).Cmd(entry.Command))
+			}
+			res = append(res, guardStep(n+1, entry.Instruction(), command)...)
+		case entry.Directive == "ENV":
+			pairs, err := parseKeyValues(entry.Command)
+			if err != nil {
+				continue
+			}
+			res = append(res, "# "+entry.Instruction())
+			for _, pair := range pairs {
+				res = append(res, fmt.Sprintf("export %s=%s", pair[0], shellQuote(pair[1])))
+			}
+		case entry.Directive == "WORKDIR":
+			if err := applyDirective(config, entry.Directive, entry.Command); err != nil {
+				continue
+			}
+			res = append(res, "# "+entry.Instruction())
+			res = append(res, fmt.Sprintf("mkdir -p %s && cd %s", shellQuote(config.WorkingDir), shellQuote(config.WorkingDir)))
+		case entry.Directive == "COPY":
+			res = append(res, guardStep(n+1, entry.Instruction(), copyCommand(entry.Command, config.WorkingDir))...)
+		default:
+			res = append(res, "# "+entry.Instruction()+" (not applicable to a shell script)")
+		}
+	}
+	return res
+}
+
+// guardStep wraps command so it only runs when its marker file is missing.
+// The marker includes a hash of the command so edited steps run again.
+func guardStep(n int, comment string, command string) []string {
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(command)))[:8]
+	marker := fmt.Sprintf(`"$CYCLOPS_STATE/%03d-%s"`, n, hash)
+	return []string{
+		"# " + comment,
+		"if [ ! -e " + marker + " ]; then",
+		"  " + command,
+		"  touch " + marker,
+		"fi",
+	}
+}
+
+// copyCommand converts COPY arguments to cp, using the directory of the
+// script as the build context
+func copyCommand(args string, workdir string) string {
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		return "false # invalid COPY " + args
+	}
+	src := `"$CYCLOPS_CONTEXT"/` + shellQuote(parts[0])
+	dest := copyDest(parts[1], workdir)
+	if info, err := os.Stat(parts[0]); err == nil && info.IsDir() {
+		return fmt.Sprintf("mkdir -p %s && cp -R %s/. %s", shellQuote(path.Clean(dest)), src, shellQuote(path.Clean(dest)))
+	}
+	dir := path.Dir(dest)
+	if strings.HasSuffix(dest, "/") {
+		dir = path.Clean(dest)
+	}
+	return fmt.Sprintf("mkdir -p %s && cp %s %s", shellQuote(dir), src, shellQuote(dest))
+}
+
+// shellQuote quotes s for use as a single shell word
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellQuote(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("/usr/local/bin", shellQuote("/usr/local/bin"))
+	assert.Equal("''", shellQuote(""))
+	assert.Equal("'a b'", shellQuote("a b"))
+	assert.Equal(`'it'\''s'`, shellQuote("it's"))
+	assert.Equal("python -c 'print(1)'", shellJoin([]string{"python", "-c", "print(1)"}))
+}
+
+func TestRenderShellScript(t *testing.T) {
+	assert := assert.New(t)
+
+	history := []EvalResult{
+		{Command: "apt-get update", Mode: "bash"},
+		{Command: "FOO=bar BAZ=\"a b\"", Directive: "ENV"},
+		{Command: "/app", Directive: "WORKDIR"},
+		{Command: "src", Directive: "WORKDIR"},
+		{Command: "print(1)", Mode: "python"},
+		{Command: "nginx.conf conf/", Directive: "COPY"},
+		{Command: "80", Directive: "EXPOSE"},
+	}
+	script := renderShellScript("ubuntu:trusty", history)
+
+	assert.Equal([]string{
+		"#!/bin/bash",
+		"# Generated by cyclops",
+		"# Validated against docker image: ubuntu:trusty",
+		"set -euo pipefail",
+		"",
+		`CYCLOPS_CONTEXT="$(cd "$(dirname "$0")" && pwd)"`,
+		`CYCLOPS_STATE="${CYCLOPS_STATE:-/var/lib/cyclops/$(basename "$0" .sh)}"`,
+		`mkdir -p "$CYCLOPS_STATE"`,
+		"",
+		"# RUN apt-get update",
+		`if [ ! -e "$CYCLOPS_STATE/001-b05f96a2" ]; then`,
+		"  apt-get update",
+		`  touch "$CYCLOPS_STATE/001-b05f96a2"`,
+		"fi",
+		"",
+		`# ENV FOO=bar BAZ="a b"`,
+		"export FOO=bar",
+		"export BAZ='a b'",
+		"",
+		"# WORKDIR /app",
+		"mkdir -p /app && cd /app",
+		"",
+		"# WORKDIR src",
+		"mkdir -p /app/src && cd /app/src",
+		"",
+		`# RUN ["python","-c","print(1)"]`,
+		`if [ ! -e "$CYCLOPS_STATE/005-7c844863" ]; then`,
+		"  python -c 'print(1)'",
+		`  touch "$CYCLOPS_STATE/005-7c844863"`,
+		"fi",
+		"",
+		"# COPY nginx.conf conf/",
+		`if [ ! -e "$CYCLOPS_STATE/006-5dbce945" ]; then`,
+		`  mkdir -p /app/src/conf && cp "$CYCLOPS_CONTEXT"/nginx.conf /app/src/conf/`,
+		`  touch "$CYCLOPS_STATE/006-5dbce945"`,
+		"fi",
+		"",
+		"# EXPOSE 80 (not applicable to a shell script)",
+	}, script)
+}
+
+func TestWorkspaceWriteWith(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+
+	ws.Run("apt-get update")
+	assert.NoError(ws.WriteWith(".test/install.sh", RenderOptions{Format: "sh"}))
+	info, err := os.Stat(".test/install.sh")
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0755), info.Mode().Perm())
+	out, _ := ioutil.ReadFile(".test/install.sh")
+	assert.Contains(string(out), "  apt-get update\n")
+
+	assert.Equal(ErrInvalidFormat, ws.WriteWith(".test/install.bat", RenderOptions{Format: "bat"}))
+
+	state, err := ws.SprintWith(RenderOptions{Format: "dockerfile"})
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN apt-get update"}, state)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	ErrUnknownService     = errors.New("No such service")
+	ErrServiceExists      = errors.New("Service already running")
+	ErrInvalidServiceName = errors.New("Service names may only contain letters, digits, _, . and -")
+)
+
+var serviceName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Service is a detached container started from the current image, kept
+// running across evaluations. Evaluations reach it by its name.
+type Service struct {
+	Name    string   `json:"name"`
+	Id      string   `json:"id"` //container ID
+	Image   string   `json:"image"`
+	Command string   `json:"command"`
+	Ports   []string `json:"ports"` //published ports as given, e.g. 8080:80
+}
+
+// StartService runs command with /bin/sh in a detached container from the
+// current image, publishing ports given as [[ip:]host:]container[/proto].
+// Services are linked into the containers of later evaluations and
+// services under their name, and are never committed.
+func (w *Workspace) StartService(name string, command string, ports []string) (Service, error) {
+	svc := Service{Name: name, Image: w.CurrentImage, Command: command, Ports: ports}
+	if !serviceName.MatchString(name) {
+		return svc, ErrInvalidServiceName
+	}
+	if existing, ok := w.service(name); ok {
+		if w.running(existing) {
+			return svc, ErrServiceExists
+		}
+		// the name of a service that exited can be reused
+		if _, err := w.StopService(name); err != nil {
+			return svc, err
+		}
+	}
+	opts := w.evalOptions(command)
+	opts.Cmd = []string{"/bin/sh", "-c", command}
+	options := evalContainer(command, w.CurrentImage, opts)
+	for _, spec := range ports {
+		port, binding, err := parsePort(spec)
+		if err != nil {
+			return svc, err
+		}
+		if options.Config.ExposedPorts == nil {
+			options.Config.ExposedPorts = map[docker.Port]struct{}{}
+			options.HostConfig.PortBindings = map[docker.Port][]docker.PortBinding{}
+		}
+		options.Config.ExposedPorts[port] = struct{}{}
+		options.HostConfig.PortBindings[port] = append(options.HostConfig.PortBindings[port], binding)
+	}
+
+	cont, err := w.docker.CreateContainer(options)
+	if err != nil {
+		return svc, err
+	}
+	svc.Id = cont.ID
+	if err := w.docker.StartContainer(cont.ID, options.HostConfig); err != nil {
+		RemoveContainer(w.docker, cont.ID)
+		return svc, err
+	}
+	w.services = append(w.services, svc)
+	w.warm()
+	return svc, nil
+}
+
+// StopService stops and removes the container of a service. A service
+// that already exited is only removed.
+func (w *Workspace) StopService(name string) (Service, error) {
+	svc, ok := w.service(name)
+	if !ok {
+		return svc, ErrUnknownService
+	}
+	err := w.docker.StopContainer(svc.Id, stopGrace)
+	if _, exited := err.(*docker.ContainerNotRunning); exited {
+		err = nil
+	}
+	if rerr := RemoveContainer(w.docker, svc.Id); rerr != nil {
+		if err == nil {
+			err = rerr
+		}
+		return svc, err
+	}
+	for i, s := range w.services {
+		if s.Name == name {
+			w.services = append(w.services[:i], w.services[i+1:]...)
+			break
+		}
+	}
+	w.warm()
+	return svc, err
+}
+
+// StopServices stops all services, before exiting
+func (w *Workspace) StopServices() (results []ResetResult) {
+	for _, svc := range append([]Service{}, w.services...) {
+		_, err := w.StopService(svc.Name)
+		results = append(results, ResetResult{Err: err, Id: svc.Id})
+	}
+	return
+}
+
+// ServiceLogs writes the output of a service so far to out
+func (w *Workspace) ServiceLogs(name string, out io.Writer) error {
+	svc, ok := w.service(name)
+	if !ok {
+		return ErrUnknownService
+	}
+	return w.docker.Logs(docker.LogsOptions{
+		Container:    svc.Id,
+		OutputStream: out,
+		ErrorStream:  out,
+		Stdout:       true,
+		Stderr:       true,
+	})
+}
+
+// Services returns the running services in the order they were started.
+// Services that exited are left out until they are stopped.
+func (w *Workspace) Services() []Service {
+	running := []Service{}
+	for _, svc := range w.services {
+		if w.running(svc) {
+			running = append(running, svc)
+		}
+	}
+	return running
+}
+
+// running reports whether the container of svc is still running
+func (w *Workspace) running(svc Service) bool {
+	cont, err := w.docker.InspectContainer(svc.Id)
+	return err == nil && cont.State.Running
+}
+
+func (w *Workspace) service(name string) (Service, bool) {
+	for _, svc := range w.services {
+		if svc.Name == name {
+			return svc, true
+		}
+	}
+	return Service{}, false
+}
+
+// serviceLinks links the running services into a container by name. Links don't
+// work with the host network or without networking.
+func (w *Workspace) serviceLinks() []string {
+	if w.Runtime.Network != "" && w.Runtime.Network != "bridge" {
+		return nil
+	}
+	var links []string
+	for _, svc := range w.Services() {
+		links = append(links, svc.Id+":"+svc.Name)
+	}
+	return links
+}
+
+// parsePort parses a published port like docker run -p does
+func parsePort(spec string) (docker.Port, docker.PortBinding, error) {
+	var binding docker.PortBinding
+	port, proto := spec, "tcp"
+	if i := strings.Index(spec, "/"); i > -1 {
+		port, proto = spec[:i], spec[i+1:]
+	}
+	parts := strings.Split(port, ":")
+	switch len(parts) {
+	case 1:
+	case 2:
+		binding.HostPort = parts[0]
+	case 3:
+		binding.HostIP, binding.HostPort = parts[0], parts[1]
+	default:
+		return "", binding, fmt.Errorf("invalid port %s", spec)
+	}
+	container := parts[len(parts)-1]
+	if !isPortNumber(container) || (binding.HostPort != "" && !isPortNumber(binding.HostPort)) || (proto != "tcp" && proto != "udp") {
+		return "", binding, fmt.Errorf("invalid port %s", spec)
+	}
+	return docker.Port(container + "/" + proto), binding, nil
+}
+
+func isPortNumber(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n < 65536
+}
+
+// parseService splits the arguments of :service start into the name,
+// the --publish options and the command, which is kept as entered
+func parseService(args string) (string, []string, string) {
+	name, rest := nextField(args)
+	ports := []string{}
+	for {
+		field, after := nextField(rest)
+		if !strings.HasPrefix(field, "--publish=") {
+			break
+		}
+		ports = append(ports, strings.TrimPrefix(field, "--publish="))
+		rest = after
+	}
+	return name, ports, strings.TrimSpace(rest)
+}
+
+// nextField splits off the first whitespace separated field of s
+func nextField(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i > -1 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartService(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	svc, err := ws.StartService("db", "postgres -D /data", []string{"5432", "8080:80"})
+	assert.NoError(err)
+	assert.Equal("c1", svc.Id)
+	created := mockdock.Created[0]
+	assert.Equal([]string{"/bin/sh", "-c", "postgres -D /data"}, created.Config.Cmd)
+	assert.Equal(map[docker.Port]struct{}{"5432/tcp": {}, "80/tcp": {}}, created.Config.ExposedPorts)
+	assert.Equal([]docker.PortBinding{{HostPort: "8080"}}, created.HostConfig.PortBindings["80/tcp"])
+
+	_, err = ws.StartService("db", "postgres", nil)
+	assert.Equal(ErrServiceExists, err)
+	_, err = ws.StartService("my db", "postgres", nil)
+	assert.Equal(ErrInvalidServiceName, err)
+
+	// later evaluations and services are linked to the service
+	_, err = ws.StartService("web", "nginx", nil)
+	assert.NoError(err)
+	assert.Equal([]string{"c1:db"}, mockdock.Created[1].HostConfig.Links)
+	_, err = ws.Eval("curl web")
+	assert.NoError(err)
+	assert.Equal([]string{"c1:db", "c2:web"}, mockdock.Created[2].HostConfig.Links)
+	assert.Len(ws.history, 1)
+
+	ws.Runtime.Set("network", "host")
+	ws.Eval("curl localhost")
+	assert.Nil(mockdock.Created[3].HostConfig.Links)
+}
+
+func TestStopService(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	ws.StartService("db", "postgres", nil)
+	ws.StartService("web", "nginx", nil)
+
+	svc, err := ws.StopService("db")
+	assert.NoError(err)
+	assert.Equal("c1", svc.Id)
+	assert.Equal([]string{"c1"}, mockdock.Stopped)
+	assert.Len(ws.Services(), 1)
+	_, err = ws.StopService("db")
+	assert.Equal(ErrUnknownService, err)
+
+	results := ws.StopServices()
+	assert.Equal([]ResetResult{{Id: "c2"}}, results)
+	assert.Empty(ws.Services())
+}
+
+func TestStopServiceExited(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	ws.StartService("db", "postgres", nil)
+	ws.StartService("web", "nginx", nil)
+
+	// exited services aren't listed or linked
+	mockdock.Exited["c1"] = true
+	assert.Len(ws.Services(), 1)
+	assert.Equal("web", ws.Services()[0].Name)
+	assert.Equal([]string{"c2:web"}, ws.serviceLinks())
+
+	// the name can be reused, removing the exited container
+	svc, err := ws.StartService("db", "postgres", nil)
+	assert.NoError(err)
+	assert.Equal("c3", svc.Id)
+	_, err = mockdock.InspectContainer("c1")
+	assert.Error(err)
+
+	mockdock.Exited["c3"] = true
+	_, err = ws.StopService("db")
+	assert.NoError(err)
+	_, err = mockdock.InspectContainer("c3")
+	assert.Error(err)
+	assert.Len(ws.Services(), 1)
+	assert.Empty(mockdock.Stopped)
+}
+
+func TestServiceLogs(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	mockdock.Outputs = map[string]string{"ubuntu:trusty": "ready to accept connections\n"}
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	ws.StartService("db", "postgres", nil)
+
+	var out bytes.Buffer
+	assert.NoError(ws.ServiceLogs("db", &out))
+	assert.Equal("ready to accept connections\n", out.String())
+	assert.Equal(ErrUnknownService, ws.ServiceLogs("web", &out))
+}
+
+func TestParsePort(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		spec    string
+		port    docker.Port
+		binding docker.PortBinding
+	}{
+		{"80", "80/tcp", docker.PortBinding{}},
+		{"8080:80", "80/tcp", docker.PortBinding{HostPort: "8080"}},
+		{"127.0.0.1:8080:80", "80/tcp", docker.PortBinding{HostIP: "127.0.0.1", HostPort: "8080"}},
+		{"53:53/udp", "53/udp", docker.PortBinding{HostPort: "53"}},
+	}
+	for _, test := range tests {
+		port, binding, err := parsePort(test.spec)
+		assert.NoError(err, test.spec)
+		assert.Equal(test.port, port, test.spec)
+		assert.Equal(test.binding, binding, test.spec)
+	}
+	for _, spec := range []string{"http", "80/sctp", "0", "a:b:c:80"} {
+		_, _, err := parsePort(spec)
+		assert.Error(err, spec)
+	}
+}
+
+func TestParseService(t *testing.T) {
+	assert := assert.New(t)
+	name, ports, command := parseService("web --publish=8080:80 --publish=443 nginx -g 'daemon off;'")
+	assert.Equal("web", name)
+	assert.Equal([]string{"8080:80", "443"}, ports)
+	assert.Equal("nginx -g 'daemon off;'", command)
+
+	name, ports, command = parseService("db")
+	assert.Equal("db", name)
+	assert.Empty(ports)
+	assert.Equal("", command)
+}
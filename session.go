@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Session is the on-disk representation of a Workspace
+type Session struct {
+	Mode         string
+	Runtime      RuntimeOptions
+	Image        string //configured base image
+	CurrentImage string
+	History      []EvalResult
+	Head         int            //index of the last step on the current branch
+	Marks        map[string]int //checkpoint name to history index
+}
+
+// Save writes the workspace state to the provided file as JSON. The file
+// will be created, if necessary and overwrite the contents if it already exists
+func (w *Workspace) Save(path string) error {
+	session := Session{
+		Mode:         w.Mode,
+		Runtime:      w.Runtime,
+		Image:        w.Image,
+		CurrentImage: w.CurrentImage,
+		History:      w.history,
+		Head:         w.head,
+		Marks:        w.marks,
+	}
+	out, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// Load replaces the workspace state with the session stored in the provided
+// file. Committed images are reconciled against the docker daemon; steps are
+// dropped from the first image that no longer exists. Returns the number of
+// steps that had to be dropped.
+func (w *Workspace) Load(path string) (int, error) {
+	session, err := readSession(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := w.ensureImage(session.Image); err != nil {
+		return 0, err
+	}
+	return w.restore(session), nil
+}
+
+func readSession(path string) (Session, error) {
+	var session Session
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return session, err
+	}
+	if err := json.Unmarshal(data, &session); err != nil {
+		return session, err
+	}
+	if session.Image == "" {
+		return session, errors.New("Session has no base image")
+	}
+	if err := session.validate(); err != nil {
+		return session, err
+	}
+	return session, nil
+}
+
+// validate checks the mode and the history indexes of a session read from
+// a file, which are used without bounds checks once loaded
+func (s *Session) validate() error {
+	if s.Mode == "" {
+		s.Mode = defaultMode
+	}
+	if _, ok := modes[s.Mode]; !ok {
+		return fmt.Errorf("Session has an invalid mode %q", s.Mode)
+	}
+	n := len(s.History)
+	if s.Head < -1 || s.Head >= n {
+		return fmt.Errorf("Session head %d is out of range", s.Head)
+	}
+	for name, i := range s.Marks {
+		if i < -1 || i >= n {
+			return fmt.Errorf("Session mark %s points to missing step %d", name, i)
+		}
+	}
+	// parents come before their children, which also rules out cycles
+	for i, entry := range s.History {
+		if entry.Parent < -1 || entry.Parent >= i {
+			return fmt.Errorf("Session step %d has an invalid parent %d", i, entry.Parent)
+		}
+	}
+	return nil
+}
+
+// restore replaces the workspace state with session, whose base image must
+// exist, and returns the number of steps dropped by reconcile
+func (w *Workspace) restore(session Session) int {
+	w.Mode = session.Mode
+	w.Runtime = session.Runtime
+	w.Image = session.Image
+	w.history = session.History
+	if w.history == nil {
+		w.history = []EvalResult{}
+	}
+	w.head = session.Head
+	w.marks = session.Marks
+	if w.marks == nil {
+		w.marks = map[string]int{}
+	}
+	return w.reconcile()
+}
+
+// reconcile walks the loaded history and verifies each committed image still
+// exists. Steps building on a missing image are dropped, and the head and
+// marks move back to the closest remaining step. Containers from a previous
+// run are assumed to be gone, so their ids are cleared to keep Reset and back
+// from trying to remove them.
+func (w *Workspace) reconcile() int {
+	history := w.history
+	dropped := 0
+	missing := map[int]bool{}
+	for i := range history {
+		history[i].Id = ""
+		if history[i].Deleted {
+			continue
+		}
+		parent := history[i].Parent
+		if (parent > -1 && missing[parent]) ||
+			(history[i].NewImage != "" && verifyImage(w.docker, history[i].NewImage) != nil) {
+			missing[i] = true
+			history[i].Deleted = true
+			dropped += 1
+		}
+	}
+	w.history = history
+	for w.head > -1 && missing[w.head] {
+		w.head = history[w.head].Parent
+	}
+	for name, i := range w.marks {
+		for i > -1 && missing[i] {
+			i = history[i].Parent
+		}
+		w.marks[name] = i
+	}
+	w.CurrentImage = w.imageAt(w.head)
+	return dropped
+}
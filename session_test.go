@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspaceSaveLoad(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+
+	ws.Run("cmd1")
+	ws.Eval("cmd2")
+	ws.Run("cmd3")
+	assert.NoError(ws.Save(".test/session.json"))
+
+	loaded := NewWorkspace(NewMockDockerClient(), "dockerfile", "fedora")
+	dropped, err := loaded.Load(".test/session.json")
+	assert.NoError(err)
+	assert.Equal(0, dropped)
+	assert.Equal("bash", loaded.Mode)
+	assert.Equal("ubuntu:trusty", loaded.Image)
+	assert.Equal("i3", loaded.CurrentImage)
+	assert.Len(loaded.history, 3)
+	for _, entry := range loaded.history {
+		assert.Equal("", entry.Id)
+	}
+
+	state, err := loaded.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1", "RUN cmd3"}, state)
+
+	results := loaded.Reset()
+	assert.Len(results, 0)
+}
+
+func TestWorkspaceLoadMissingImage(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+
+	ws.Run("cmd1")
+	ws.Run("cmd2")
+	ws.Run("cmd3")
+	assert.NoError(ws.Save(".test/session.json"))
+
+	mockdock := NewMockDockerClient()
+	mockdock.MissingImages = map[string]bool{"i2": true}
+	loaded := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+	dropped, err := loaded.Load(".test/session.json")
+	assert.NoError(err)
+	assert.Equal(2, dropped)
+	assert.Equal("i1", loaded.CurrentImage)
+
+	state, err := loaded.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1"}, state)
+}
+
+func TestWorkspaceLoadMissingBase(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+
+	assert.NoError(ws.Save(".test/session.json"))
+
+	mockdock := NewMockDockerClient()
+	mockdock.FailInspect = true
+	loaded := NewWorkspace(mockdock, "dockerfile", "fedora")
+	_, err := loaded.Load(".test/session.json")
+	assert.Error(err)
+	assert.Equal("fedora", loaded.Image)
+
+	_, err = loaded.Load(".test/missing.json")
+	assert.Error(err)
+}
+
+func TestWorkspaceLoadInvalid(t *testing.T) {
+	assert := assert.New(t)
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+
+	sessions := []string{
+		`{"Mode":"cobol","Image":"ubuntu:trusty","Head":-1}`,
+		`{"Mode":"bash","Image":"ubuntu:trusty","Head":1,"History":[{"parent":-1}]}`,
+		`{"Mode":"bash","Image":"ubuntu:trusty","Head":-2}`,
+		`{"Mode":"bash","Image":"ubuntu:trusty","Head":0,"History":[{"parent":-1}],"Marks":{"a":3}}`,
+		`{"Mode":"bash","Image":"ubuntu:trusty","Head":1,"History":[{"parent":1},{"parent":0}]}`,
+	}
+	for _, session := range sessions {
+		ioutil.WriteFile(".test/session.json", []byte(session), 0644)
+		loaded := NewWorkspace(NewMockDockerClient(), "bash", "fedora")
+		_, err := loaded.Load(".test/session.json")
+		assert.Error(err, session)
+		assert.Equal("fedora", loaded.Image)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// runSeparator joins squashed commands into a single RUN instruction
+// with line continuations
+const runSeparator = " && \\\n    "
+
+// squashable reports whether entry is a committed shell command that can be
+// replayed
+func squashable(entry EvalResult) bool {
+	_, shell := modeFor(entry.Mode).(shellMode)
+	return entry.Directive == "" && shell && !entry.Shell && entry.NewImage != ""
+}
+
+// squashHistory collapses consecutive committed RUN steps into a single
+// step. Deleted entries are passed through and don't break a sequence.
+func squashHistory(history []EvalResult) []EvalResult {
+	res := []EvalResult{}
+	last := -1
+	for _, entry := range history {
+		if entry.Deleted {
+			res = append(res, entry)
+			continue
+		}
+		if last > -1 && squashable(res[last]) && squashable(entry) {
+			res[last] = mergeSteps(res[last], entry)
+			continue
+		}
+		res = append(res, entry)
+		last = len(res) - 1
+	}
+	return res
+}
+
+func mergeSteps(a EvalResult, b EvalResult) EvalResult {
+	b.Command = a.Command + runSeparator + b.Command
+	b.Duration += a.Duration
+	b.Changes = append(append([]docker.Change{}, a.Changes...), b.Changes...)
+	b.Image = a.Image
+	b.Parent = a.Parent
+	return b
+}
+
+// flattenCommand formats a squashed command on a single line
+func flattenCommand(command string) string {
+	return strings.Replace(command, " \\\n    ", " ", -1)
+}
+
+// Squash collapses consecutive committed RUN steps on the current branch
+// into one step, so :back reverts them together. Steps that other branches
+// or marks build on are kept. Returns the number of steps merged.
+func (w *Workspace) Squash() int {
+	merged := 0
+	for i := w.head; i > -1; i = w.history[i].Parent {
+		for {
+			parent := w.history[i].Parent
+			if parent < 0 || !squashable(w.history[parent]) || !squashable(w.history[i]) {
+				break
+			}
+			if w.isMarked(parent) || w.liveChildren(parent) > 1 {
+				break
+			}
+			if id := w.history[parent].Id; id != "" {
+				RemoveContainer(w.docker, id)
+			}
+			w.history[i] = mergeSteps(w.history[parent], w.history[i])
+			w.history[parent].Deleted = true
+			merged += 1
+		}
+	}
+	return merged
+}
+
+// Flatten produces a single layer image from the current image, keeping
+// its config, and tags it as name if given
+func (w *Workspace) Flatten(name string) (string, error) {
+	image, err := w.resultImage()
+	if err != nil {
+		return "", err
+	}
+	inspect, err := w.docker.InspectImage(image)
+	if err != nil {
+		return "", err
+	}
+	flat, err := Flatten(w.docker, image, inspect.Config)
+	if err != nil {
+		return "", err
+	}
+	if name != "" {
+		if err := TagImage(w.docker, flat, name); err != nil {
+			return flat, err
+		}
+	}
+	return flat, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSquashHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	history := []EvalResult{
+		{Command: "cmd1", Id: "c1", Image: "base", NewImage: "i1"},
+		{Command: "ls", Id: "c2", Image: "i1", Deleted: true},
+		{Command: "cmd3", Id: "c3", Image: "i1", NewImage: "i3"},
+		{Command: "FOO=bar", Directive: "ENV", Image: "i3"},
+		{Command: "cmd5", Id: "c5", Image: "i3", NewImage: "i5"},
+		{Command: "print(1)", Id: "c6", Image: "i5", NewImage: "i6", Mode: "python"},
+		{Command: "cmd7", Id: "c7", Image: "i6", NewImage: "i7"},
+		{Command: "cmd8", Id: "c8", Image: "i7"},
+	}
+
+	squashed := squashHistory(history)
+	assert.Len(squashed, 7)
+	assert.Equal("cmd1 && \\\n    cmd3", squashed[0].Command)
+	assert.Equal("base", squashed[0].Image)
+	assert.Equal("i3", squashed[0].NewImage)
+	assert.Equal("c3", squashed[0].Id)
+	assert.True(squashed[1].Deleted)
+	assert.Equal("cmd1 && cmd3", flattenCommand(squashed[0].Command))
+}
+
+func TestMergeStepsKeepsChanges(t *testing.T) {
+	assert := assert.New(t)
+	changes := make([]docker.Change, 1, 4)
+	changes[0] = docker.Change{Path: "/a", Kind: docker.ChangeAdd}
+	a := EvalResult{Command: "cmd1", Changes: changes}
+
+	merged := mergeSteps(a, EvalResult{Command: "cmd2", Changes: []docker.Change{{Path: "/b", Kind: docker.ChangeAdd}}})
+	mergeSteps(a, EvalResult{Command: "cmd3", Changes: []docker.Change{{Path: "/c", Kind: docker.ChangeAdd}}})
+	assert.Equal([]docker.Change{{Path: "/a", Kind: docker.ChangeAdd}, {Path: "/b", Kind: docker.ChangeAdd}}, merged.Changes)
+	assert.Len(a.Changes, 1)
+}
+
+func TestWorkflowSquash(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	ws.Run("cmd1")
+	ws.Run("cmd2")
+	ws.Directive("ENV", "FOO=bar")
+	ws.Run("cmd3")
+
+	state, err := ws.SprintWith(RenderOptions{Squash: true})
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1 && \\\n    cmd2", "ENV FOO=bar", "RUN cmd3"}, state)
+	assert.Len(ws.history, 4)
+
+	assert.Equal(1, ws.Squash())
+	assert.Len(ws.history, 4)
+	assert.True(ws.history[0].Deleted)
+	assert.Len(mockdock.Containers, 2)
+
+	state, err = ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1 && \\\n    cmd2", "ENV FOO=bar", "RUN cmd3"}, state)
+
+	assert.NoError(ws.back(3))
+	assert.Equal("ubuntu:trusty", ws.CurrentImage)
+}
+
+func TestWorkspaceFlatten(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	ws.Run("cmd1")
+	image, err := ws.Flatten("cyclops/flat")
+	assert.NoError(err)
+	assert.Equal("i3", image)
+	assert.Equal("i3", mockdock.Tags["cyclops/flat:latest"])
+	assert.Equal("i1", ws.CurrentImage)
+}
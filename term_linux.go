@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+const (
+	ioctlReadTermios  = syscall.TCGETS
+	ioctlWriteTermios = syscall.TCSETS
+)
@@ -0,0 +1,20 @@
+// +build !linux,!darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoTerminal = errors.New("Terminal not supported on this platform")
+
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func terminalSize(fd uintptr) ([2]int, error) {
+	return [2]int{}, errNoTerminal
+}
+
+func notifyResize(c chan<- os.Signal) {}
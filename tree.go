@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidMark = errors.New("Invalid mark name")
+	ErrUnknownMark = errors.New("No mark or image found")
+)
+
+// The history is a tree: every step records the step it was evaluated
+// after as its Parent. Checking out an earlier step moves the head without
+// discarding later work, so new steps start a branch next to it.
+
+// add appends res to the history as a child of the head. Steps that are
+// part of the build (not deleted) become the new head.
+func (w *Workspace) add(res EvalResult) {
+	res.Parent = w.head
+	w.history = append(w.history, res)
+	if !res.Deleted {
+		w.head = len(w.history) - 1
+	}
+}
+
+// path returns the steps from the base image to the head, in order
+func (w *Workspace) path() []EvalResult {
+	steps := []EvalResult{}
+	for i := w.head; i > -1; i = w.history[i].Parent {
+		steps = append([]EvalResult{w.history[i]}, steps...)
+	}
+	return steps
+}
+
+// imageAt returns the image the state after step i is based on
+func (w *Workspace) imageAt(i int) string {
+	if i < 0 {
+		return w.Image
+	}
+	if w.history[i].NewImage != "" {
+		return w.history[i].NewImage
+	}
+	return w.history[i].Image
+}
+
+func (w *Workspace) hasLiveChildren(i int) bool {
+	return w.liveChildren(i) > 0
+}
+
+// liveChildren counts the steps that were evaluated after step i and are
+// still part of a branch
+func (w *Workspace) liveChildren(i int) int {
+	n := 0
+	for _, entry := range w.history {
+		if !entry.Deleted && entry.Parent == i {
+			n += 1
+		}
+	}
+	return n
+}
+
+func (w *Workspace) isMarked(i int) bool {
+	return len(w.marksAt(i)) > 0
+}
+
+// marksAt returns the sorted names of the marks pointing at step i
+func (w *Workspace) marksAt(i int) []string {
+	names := []string{}
+	for name, index := range w.marks {
+		if index == i {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Mark names the head as a checkpoint that can be returned to with Checkout
+func (w *Workspace) Mark(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return ErrInvalidMark
+	}
+	w.marks[name] = w.head
+	return nil
+}
+
+// Checkout moves the head to a mark, the base image, an item of the tree
+// given as #N or to the step that committed the image with the given ID
+// prefix. Steps after it are kept as a separate branch.
+func (w *Workspace) Checkout(target string) error {
+	i, ok := w.marks[target]
+	if !ok && target == w.Image {
+		i, ok = -1, true
+	}
+	if !ok && strings.HasPrefix(target, "#") {
+		n, err := strconv.Atoi(target[1:])
+		if err != nil || n < 1 || n > len(w.history) || w.history[n-1].Deleted {
+			return ErrUnknownMark
+		}
+		i, ok = n-1, true
+	}
+	if !ok {
+		// cached steps share their image, so an ID can match several steps
+		matches := []string{}
+		for n, entry := range w.history {
+			if entry.Deleted || entry.NewImage == "" || !strings.HasPrefix(entry.NewImage, target) {
+				continue
+			}
+			matches = append(matches, fmt.Sprintf("#%d", n+1))
+			i, ok = n, true
+		}
+		if len(matches) > 1 {
+			return fmt.Errorf("Ambiguous image ID %s matches %s", target, strings.Join(matches, ", "))
+		}
+	}
+	if !ok {
+		return ErrUnknownMark
+	}
+	w.head = i
+	w.CurrentImage = w.imageAt(i)
+	w.warm()
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkflowCheckout(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	ws.Run("cmd1")
+	assert.NoError(ws.Mark("before-nginx"))
+	ws.Run("cmd2")
+	assert.NoError(ws.Checkout("before-nginx"))
+	assert.Equal("i1", ws.CurrentImage)
+
+	res, _ := ws.Run("cmd3")
+	assert.Equal("i1", res.Image)
+	assert.False(ws.history[1].Deleted)
+	state, err := ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1", "RUN cmd3"}, state)
+
+	assert.NoError(ws.Checkout("i2"))
+	assert.Equal("i2", ws.CurrentImage)
+	state, err = ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1", "RUN cmd2"}, state)
+
+	assert.Equal(ErrUnknownMark, ws.Checkout("after-nginx"))
+	assert.Equal(ErrInvalidMark, ws.Mark("after nginx"))
+
+	assert.NoError(ws.Checkout("ubuntu:trusty"))
+	assert.Equal("ubuntu:trusty", ws.CurrentImage)
+	state, err = ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty"}, state)
+
+	assert.NoError(ws.Checkout("#3"))
+	assert.Equal("i3", ws.CurrentImage)
+	assert.Equal(ErrUnknownMark, ws.Checkout("#4"))
+	assert.Equal(ErrUnknownMark, ws.Checkout("#x"))
+}
+
+func TestCheckoutCachedImage(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	ws.Run("cmd1")
+	ws.Run("cmd2")
+	ws.Checkout("ubuntu:trusty")
+	ws.Run("cmd1")
+	ws.Run("cmd3")
+	assert.True(ws.history[2].Cached)
+
+	// both branches share the cached image, the tree items tell them apart
+	err := ws.Checkout("i1")
+	assert.EqualError(err, "Ambiguous image ID i1 matches #1, #3")
+	assert.NoError(ws.Checkout("#1"))
+	assert.NoError(ws.Checkout("i2"))
+	state, err := ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1", "RUN cmd2"}, state)
+}
+
+func TestWorkflowBackBranches(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	ws.Run("cmd1")
+	ws.Run("cmd2")
+	ws.Checkout("i1")
+	ws.Run("cmd3")
+
+	// cmd1 is kept since the cmd2 branch builds on it
+	assert.NoError(ws.back(2))
+	assert.Equal("ubuntu:trusty", ws.CurrentImage)
+	assert.False(ws.history[0].Deleted)
+	assert.False(ws.history[1].Deleted)
+	assert.True(ws.history[2].Deleted)
+
+	assert.NoError(ws.Checkout("i2"))
+	state, err := ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{"FROM ubuntu:trusty", "RUN cmd1", "RUN cmd2"}, state)
+}
+
+func TestWorkspaceSaveLoadMarks(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+
+	ws.Run("cmd1")
+	ws.Mark("base")
+	ws.Run("cmd2")
+	ws.Checkout("base")
+	assert.NoError(ws.Save(".test/session.json"))
+
+	loaded := NewWorkspace(NewMockDockerClient(), "bash", "fedora")
+	_, err := loaded.Load(".test/session.json")
+	assert.NoError(err)
+	assert.Equal("i1", loaded.CurrentImage)
+	assert.Equal(map[string]int{"base": 0}, loaded.marks)
+	assert.NoError(loaded.Checkout("i2"))
+	assert.Equal("i2", loaded.CurrentImage)
+}
@@ -1,32 +1,96 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
 )
 
+// EvalResult is a step in the history. It is also the schema of the
+// results written by --output=json and stored in session files.
 type EvalResult struct {
-	Command   string
-	Code      int
-	Deleted   bool
-	Duration  time.Duration
-	Log       *Buffer
-	Changes   []docker.Change
-	Id        string //container ID
-	BaseImage string //assumed base image, used during :from switches
-	Image     string //image run against
-	NewImage  string //image with committed changes
+	Command     string          `json:"command"`     //command or instruction arguments
+	Directive   string          `json:"directive"`   //Dockerfile instruction, empty for RUN
+	Mode        string          `json:"mode"`        //mode the command was evaluated in
+	Code        int             `json:"code"`        //exit code
+	Deleted     bool            `json:"deleted"`     //ephemeral or reverted, not part of the build
+	Duration    time.Duration   `json:"duration"`    //run time in nanoseconds
+	Setup       time.Duration   `json:"setup"`       //time to create or take the container, in nanoseconds
+	Log         *Buffer         `json:"log"`         //combined stdout and stderr
+	Changes     []docker.Change `json:"changes"`     //Kind is 0 for modified, 1 for added and 2 for deleted paths
+	Id          string          `json:"id"`          //container ID
+	BaseImage   string          `json:"base_image"`  //assumed base image, used during :from switches
+	Image       string          `json:"image"`       //image run against
+	NewImage    string          `json:"new_image"`   //image with committed changes
+	Parent      int             `json:"parent"`      //index of the previous step in the history, -1 for the base image
+	Shell       bool            `json:"shell"`       //interactive :shell session, can't be replayed
+	Interrupted string          `json:"interrupted"` //"timeout" or "interrupt" when the command was stopped
+	Assert      string          `json:"assert"`      //assertion kind: assert, assert-file or assert-changed
+	Cached      bool            `json:"cached"`      //NewImage was reused from an identical earlier step
+}
+
+// Instruction returns the entry formatted as a Dockerfile instruction.
+// Commands from modes other than the shells use the exec form of RUN.
+// Assertions become RUN test lines, or comments when they can't be tested
+// in a build.
+func (r EvalResult) Instruction() string {
+	if r.Shell {
+		return "# changes made in an interactive shell (" + r.Command + ")"
+	}
+	if r.Assert == "assert-file" {
+		if test := assertTest(r); test != "" {
+			return "RUN " + test
+		}
+	}
+	if r.Assert != "" && r.Assert != "assert" {
+		return "# " + r.Label()
+	}
+	if r.Directive != "" {
+		return r.Directive + " " + r.Command
+	}
+	if _, ok := modeFor(r.Mode).(shellMode); ok {
+		return "RUN " + r.Command
+	}
+	return "RUN " + execForm(modeFor(r.Mode).Cmd(r.Command))
+}
+
+// Label returns the entry the way it is shown in the history
+func (r EvalResult) Label() string {
+	switch {
+	case r.Directive != "":
+		return r.Instruction()
+	case r.Assert != "":
+		return ":" + r.Assert + " " + r.Command
+	}
+	return flattenCommand(r.Command)
 }
 
 type Workspace struct {
 	Mode         string
-	Image        string //configured base image
+	Runtime      RuntimeOptions //options of the containers commands run in
+	Image        string         //configured base image
 	CurrentImage string
 	history      []EvalResult
+	head         int                                 //index of the last step on the current branch, -1 for the base image
+	marks        map[string]int                      //checkpoint name to history index
+	tags         map[string]string                   //image ID to the name it was last tagged as
+	configured   map[string]string                   //image and pending config to the image resultImage committed
+	ignore       []string                            //patterns of paths hidden from the changes
+	cancel       <-chan struct{}                     //closed to stop the running command
+	Pull         string                              //pull policy for base images: always, missing or never
+	auths        map[string]docker.AuthConfiguration //registry host to credentials
+	pullOutput   io.Writer                           //receives the progress of pulls
+	cache        *layerCache                         //images committed by earlier runs
+	NoCache      bool                                //always run, still adding to the cache
+	pool         *containerPool                      //containers created ahead for the current image, nil when disabled
+	services     []Service                           //running services, linked into evaluations
 	docker       DockerService
 }
 
@@ -36,19 +100,29 @@ func NewWorkspace(docker DockerService, mode string, image string) *Workspace {
 		Image:        image,
 		CurrentImage: image,
 		history:      []EvalResult{},
+		head:         -1,
+		marks:        map[string]int{},
+		tags:         map[string]string{},
+		configured:   map[string]string{},
+		ignore:       append([]string{}, defaultIgnore...),
+		Pull:         pullMissing,
+		pullOutput:   os.Stdout,
+		cache:        newLayerCache(""),
 		docker:       docker,
 	}
 	return ws
 }
 
+// SetImage sets the base image, pulling it according to the pull policy
 func (w *Workspace) SetImage(image string) error {
-	if err := verifyImage(w.docker, image); err != nil {
+	if err := w.ensureImage(image); err != nil {
 		return err
 	}
 	if w.CurrentImage == w.Image {
 		w.CurrentImage = image
 	}
 	w.Image = image
+	w.warm()
 	return nil
 }
 
@@ -57,10 +131,13 @@ func (w *Workspace) CommitLast() (string, error) {
 	if len(history) == 0 {
 		return "", errors.New("No container found to commit")
 	}
-	lastResult := len(w.history)-1
+	lastResult := len(w.history) - 1
 	if history[lastResult].NewImage != "" {
 		return "", errors.New("Container already committed")
 	}
+	if history[lastResult].Id == "" {
+		return "", errors.New("No container found to commit")
+	}
 
 	image, err := w.commit(history[lastResult].Id)
 	if err != nil {
@@ -69,11 +146,18 @@ func (w *Workspace) CommitLast() (string, error) {
 	history[lastResult].NewImage = image
 	history[lastResult].Deleted = false
 	w.history = history
+	w.head = lastResult
 	return image, nil
 }
 
-// Run runs Eval but also auto-commits on return code 0
+// Run runs Eval but also auto-commits on return code 0. An identical step
+// committed before on the same image is reused from the cache instead.
 func (w *Workspace) Run(command string) (EvalResult, error) {
+	key := w.runKey(command)
+	if res, ok := w.runCached(command, key); ok {
+		w.add(res)
+		return res, nil
+	}
 	res, err := w.evalCommand(command)
 	if res.Code == 0 {
 		if imageId, err := w.commit(res.Id); err == nil {
@@ -82,7 +166,36 @@ func (w *Workspace) Run(command string) (EvalResult, error) {
 			fmt.Println(err)
 		}
 	}
-	w.history = append(w.history, res)
+	if err == nil && res.NewImage != "" && res.Interrupted == "" && key != "" {
+		if err := w.cache.put(key, res); err != nil {
+			fmt.Println("error saving cache:", err)
+		}
+	}
+	w.add(res)
+	return res, err
+}
+
+// Shell runs cmd, or the shell of the mode, interactively in a container
+// from the current image. The session is added to the history like Eval,
+// so CommitLast commits it.
+func (w *Workspace) Shell(cmd string, in io.Reader, out io.Writer, resize <-chan [2]int) (EvalResult, error) {
+	if cmd == "" {
+		cmd = "/bin/sh"
+		if mode, ok := w.mode().(shellMode); ok {
+			cmd = mode.shell
+		}
+	}
+	opts := EvalOptions{
+		Cmd:     strings.Fields(cmd),
+		Config:  w.Config(),
+		Runtime: w.Runtime,
+		Links:   w.serviceLinks(),
+	}
+	res, err := Interactive(w.docker, w.CurrentImage, opts, in, out, resize)
+	res.Shell = true
+	res.Deleted = true
+	res.BaseImage = w.Image
+	w.add(res)
 	return res, err
 }
 
@@ -90,16 +203,59 @@ func (w *Workspace) Run(command string) (EvalResult, error) {
 func (w *Workspace) Eval(command string) (EvalResult, error) {
 	res, err := w.evalCommand(command)
 	res.Deleted = true
-	w.history = append(w.history, res)
+	w.add(res)
 	return res, err
 }
 
+// Directive records a Dockerfile instruction in the history. The resulting
+// config is applied to subsequent evaluations and commits.
+func (w *Workspace) Directive(instruction string, args string) (EvalResult, error) {
+	res := EvalResult{
+		Command:   args,
+		Directive: instruction,
+		BaseImage: w.Image,
+		Image:     w.CurrentImage,
+	}
+	if err := applyDirective(w.Config(), instruction, args); err != nil {
+		return res, err
+	}
+	w.add(res)
+	return res, nil
+}
+
+// Config returns the container config built from the directives on the
+// current branch
+func (w *Workspace) Config() *docker.Config {
+	config := &docker.Config{}
+	for _, entry := range w.path() {
+		if entry.Directive != "" && entry.Directive != "COPY" {
+			applyDirective(config, entry.Directive, entry.Command)
+		}
+	}
+	return config
+}
+
 func (w *Workspace) evalCommand(command string) (EvalResult, error) {
-	res, err := Eval(w.docker, command, w.CurrentImage)
+	opts := w.evalOptions(command)
+	opts.Cancel = w.cancel
+	opts.Pool = w.pool
+	res, err := Eval(w.docker, command, w.CurrentImage, opts)
+	res.Mode = w.Mode
 	res.BaseImage = w.Image
 	return res, err
 }
 
+// evalOptions returns the options command is evaluated with in the
+// current state
+func (w *Workspace) evalOptions(command string) EvalOptions {
+	return EvalOptions{
+		Cmd:     w.mode().Cmd(command),
+		Config:  w.Config(),
+		Runtime: w.Runtime,
+		Links:   w.serviceLinks(),
+	}
+}
+
 type ResetResult struct {
 	Err error
 	Id  string
@@ -110,31 +266,72 @@ func (w *Workspace) Reset() (results []ResetResult) {
 	history := w.history
 	for i, entry := range history {
 		if !history[i].Deleted {
-			err := RemoveContainer(w.docker, entry.Id)
-			results = append(results, ResetResult{Err: err, Id: entry.Id})
+			if entry.Id != "" {
+				err := RemoveContainer(w.docker, entry.Id)
+				results = append(results, ResetResult{Err: err, Id: entry.Id})
+			}
 			history[i].Deleted = true
 		}
 	}
 	w.history = history
+	w.head = -1
+	w.marks = map[string]int{}
 	w.CurrentImage = w.Image
+	w.warm()
 	return
 }
 
 func (w *Workspace) Sprint() ([]string, error) {
-	res := []string{"FROM " + w.Image}
-	for _, entry := range w.history {
-		if !entry.Deleted {
-			res = append(res, "RUN "+entry.Command)
-		}
+	return w.SprintWith(RenderOptions{})
+}
+
+// RenderOptions controls the output of SprintWith and WriteWith
+type RenderOptions struct {
+	Format string // output format, defaults to the format of the session mode
+	Squash bool   // collapse consecutive RUN steps into one
+}
+
+// SprintWith renders the current branch of the history with the given options
+func (w *Workspace) SprintWith(opts RenderOptions) ([]string, error) {
+	history := w.path()
+	if opts.Squash {
+		history = squashHistory(history)
 	}
-	return res, nil
+	if opts.Format == "" {
+		return w.mode().Render(w.Image, history), nil
+	}
+	render, ok := formats[opts.Format]
+	if !ok {
+		return nil, ErrInvalidFormat
+	}
+	return render(w.Image, history), nil
+}
+
+// SetMode switches the mode used for subsequent evaluations and for
+// rendering the history
+func (w *Workspace) SetMode(mode string) error {
+	if _, ok := modes[mode]; !ok {
+		return ErrInvalidMode
+	}
+	w.Mode = mode
+	return nil
+}
+
+func (w *Workspace) mode() Mode {
+	return modeFor(w.Mode)
 }
 
 // Write writes the output from Sprint to the provided file
 //  The file will be created, if necessary and overwrite the contents
 //  if it already exists
 func (w *Workspace) Write(path string) error {
-	lines, err := w.Sprint()
+	return w.WriteWith(path, RenderOptions{})
+}
+
+// WriteWith writes the output from SprintWith to the provided file.
+// Shell scripts are written executable.
+func (w *Workspace) WriteWith(path string, opts RenderOptions) error {
+	lines, err := w.SprintWith(opts)
 	if err != nil {
 		return err
 	}
@@ -142,35 +339,110 @@ func (w *Workspace) Write(path string) error {
 	for _, line := range lines {
 		out = append(out, []byte(line+"\n")...)
 	}
-	return ioutil.WriteFile(path, out, 0644)
+	perm := os.FileMode(0644)
+	if opts.Format == "sh" {
+		perm = 0755
+	}
+	return ioutil.WriteFile(path, out, perm)
 }
 
 func (w *Workspace) commit(id string) (string, error) {
-	imageId, err := CommitContainer(w.docker, id)
+	imageId, err := CommitContainer(w.docker, id, w.Config())
 	if err == nil {
 		w.CurrentImage = imageId
+		w.warm()
 	}
 	return imageId, err
 }
 
+// Tag tags the current image as name. Directives that were not committed
+// yet are baked into a new image first so the tagged image carries them.
+func (w *Workspace) Tag(name string) (string, error) {
+	image, err := w.resultImage()
+	if err != nil {
+		return "", err
+	}
+	if err := TagImage(w.docker, image, name); err != nil {
+		return "", err
+	}
+	repo, tag := repositoryTag(name)
+	w.tags[image] = repo + ":" + tag
+	return image, nil
+}
+
+// Export writes the current image to path as a tarball for docker load.
+// The image is exported by the name it was tagged as, if any, so the tag
+// survives the round trip.
+func (w *Workspace) Export(path string) error {
+	image, err := w.resultImage()
+	if err != nil {
+		return err
+	}
+	name, ok := w.tags[image]
+	if !ok {
+		name = image
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ExportImage(w.docker, name, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// resultImage returns the image representing the current branch. Pending
+// directives are committed once, so Tag and Export of the same state refer
+// to the same image.
+func (w *Workspace) resultImage() (string, error) {
+	pending := false
+	for _, entry := range w.path() {
+		if entry.NewImage != "" {
+			pending = false
+		} else if entry.Directive != "" && entry.Directive != "COPY" {
+			pending = true
+		}
+	}
+	if !pending {
+		return w.CurrentImage, nil
+	}
+	config := w.Config()
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	key := w.CurrentImage + " " + string(data)
+	if image, ok := w.configured[key]; ok && verifyImage(w.docker, image) == nil {
+		return image, nil
+	}
+	image, err := CommitConfig(w.docker, w.CurrentImage, config)
+	if err != nil {
+		return "", err
+	}
+	w.configured[key] = image
+	return image, nil
+}
+
+// back moves the head n steps up the current branch. Steps that no other
+// branch or mark builds on are discarded along with their containers.
 func (w *Workspace) back(n int) error {
-	history := w.history
-	deleted := 0
-	if n > len(history) {
+	if n > len(w.path()) {
 		return errors.New("no history that far back")
 	}
-	for i := len(history) - 1; i > -1; i -= 1 {
-		if history[i].Deleted {
-			continue
-		}
-		RemoveContainer(w.docker, history[i].Id)
-		history[i].Deleted = true
-		deleted += 1
-		if deleted == n {
-			w.CurrentImage = history[i].Image
-			break
+	for i := 0; i < n; i++ {
+		entry := &w.history[w.head]
+		if !w.hasLiveChildren(w.head) && !w.isMarked(w.head) {
+			if entry.Id != "" {
+				RemoveContainer(w.docker, entry.Id)
+			}
+			entry.Deleted = true
 		}
+		w.head = entry.Parent
+		w.CurrentImage = entry.Image
 	}
-	w.history = history
+	w.warm()
 	return nil
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -210,3 +212,159 @@ func TestWorkflowBack(t *testing.T) {
 	assert.True(ws.history[2].Deleted)
 	assert.Equal(expectedState, state)
 }
+
+func TestWorkflowDirective(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "dockerfile", "ubuntu:trusty")
+
+	ws.Run("cmd1")
+	res, err := ws.Directive("ENV", "FOO=bar")
+	assert.NoError(err)
+	assert.Equal("ENV FOO=bar", res.Instruction())
+	assert.Equal("i1", res.Image)
+	assert.Equal("", res.NewImage)
+	assert.Len(mockdock.Containers, 1)
+
+	ws.Directive("WORKDIR", "/app")
+	ws.Run("cmd2")
+
+	config := ws.Config()
+	assert.Equal([]string{"FOO=bar"}, config.Env)
+	assert.Equal("/app", config.WorkingDir)
+
+	_, err = ws.Directive("ENV", "FOO")
+	assert.Error(err)
+
+	state, err := ws.Sprint()
+	assert.NoError(err)
+	expectedState := []string{"FROM ubuntu:trusty", "RUN cmd1", "ENV FOO=bar", "WORKDIR /app", "RUN cmd2"}
+	assert.Equal(expectedState, state)
+
+	_, err = ws.CommitLast()
+	assert.Error(err)
+
+	err = ws.back(2)
+	assert.NoError(err)
+	assert.Equal("i1", ws.CurrentImage)
+	assert.Equal("", ws.Config().WorkingDir)
+	assert.Equal([]string{"FOO=bar"}, ws.Config().Env)
+}
+
+func TestWorkflowMode(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	ws.Run("apt-get install -y python")
+	assert.Error(ws.SetMode("cobol"))
+	assert.NoError(ws.SetMode("python"))
+	res, err := ws.Run("print(1)")
+	assert.NoError(err)
+	assert.Equal("python", res.Mode)
+
+	state, err := ws.Sprint()
+	assert.NoError(err)
+	expectedState := []string{"#!/usr/bin/env python", "# validated against ubuntu:trusty", "# RUN apt-get install -y python", "print(1)"}
+	assert.Equal(expectedState, state)
+
+	assert.NoError(ws.SetMode("sh"))
+	state, err = ws.Sprint()
+	assert.NoError(err)
+	expectedState = []string{"FROM ubuntu:trusty", "RUN apt-get install -y python", `RUN ["python","-c","print(1)"]`}
+	assert.Equal(expectedState, state)
+}
+
+func TestWorkflowTagExport(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	os.Mkdir(".test", 0755)
+	defer os.RemoveAll(".test")
+
+	ws.Run("cmd1")
+	image, err := ws.Tag("cyclops/test")
+	assert.NoError(err)
+	assert.Equal("i1", image)
+	assert.Equal("i1", mockdock.Tags["cyclops/test:latest"])
+
+	assert.NoError(ws.Export(".test/image.tar"))
+	out, _ := ioutil.ReadFile(".test/image.tar")
+	assert.Equal("cyclops/test:latest", string(out))
+
+	// uncommitted directives are baked into a new image
+	ws.Directive("CMD", "nginx")
+	image, err = ws.Tag("cyclops/test:cmd")
+	assert.NoError(err)
+	assert.Equal("i2", image)
+	assert.Equal("i1", ws.CurrentImage)
+	assert.Len(mockdock.Containers, 1)
+
+	// the image with the directives is exported under its tag
+	assert.NoError(ws.Export(".test/image.tar"))
+	out, _ = ioutil.ReadFile(".test/image.tar")
+	assert.Equal("cyclops/test:cmd", string(out))
+
+	// further directives aren't part of the tagged image
+	ws.Directive("ENV", "FOO=bar")
+	assert.NoError(ws.Export(".test/image.tar"))
+	out, _ = ioutil.ReadFile(".test/image.tar")
+	assert.Equal("i3", string(out))
+
+	ws.back(1)
+	assert.NoError(ws.Export(".test/image.tar"))
+	out, _ = ioutil.ReadFile(".test/image.tar")
+	assert.Equal("cyclops/test:cmd", string(out))
+
+	ws.Run("cmd3")
+	assert.NoError(ws.Export(".test/image.tar"))
+	out, _ = ioutil.ReadFile(".test/image.tar")
+	assert.Equal("i4", string(out))
+
+	mockdock.FailExport = true
+	assert.Error(ws.Export(".test/failed.tar"))
+	_, err = os.Stat(".test/failed.tar")
+	assert.True(os.IsNotExist(err))
+}
+
+func TestWorkflowShell(t *testing.T) {
+	assert := assert.New(t)
+	ws := NewWorkspace(NewMockDockerClient(), "bash", "ubuntu:trusty")
+
+	ws.Run("cmd1")
+	res, err := ws.Shell("", strings.NewReader("vim /etc/motd\nexit\n"), ioutil.Discard, make(chan [2]int))
+	assert.NoError(err)
+	assert.Equal("/bin/bash", res.Command)
+	assert.Equal("i1", res.Image)
+	assert.True(ws.history[1].Shell)
+	assert.True(ws.history[1].Deleted)
+
+	_, err = ws.CommitLast()
+	assert.NoError(err)
+	ws.Run("cmd3")
+
+	state, err := ws.Sprint()
+	assert.NoError(err)
+	assert.Equal([]string{
+		"FROM ubuntu:trusty",
+		"RUN cmd1",
+		"# changes made in an interactive shell (/bin/bash)",
+		"RUN cmd3",
+	}, state)
+	assert.Equal(0, ws.Squash())
+}
+
+func TestWorkflowTimeout(t *testing.T) {
+	assert := assert.New(t)
+	mockdock := NewMockDockerClient()
+	ws := NewWorkspace(mockdock, "bash", "ubuntu:trusty")
+
+	mockdock.Hang = true
+	ws.Runtime.Set("timeout", "10ms")
+	res, err := ws.Run("apt-get install")
+	assert.NoError(err)
+	assert.Equal("timeout", res.Interrupted)
+	assert.Equal("", res.NewImage)
+	assert.Equal("ubuntu:trusty", ws.CurrentImage)
+	assert.Equal("timeout", ws.history[0].Interrupted)
+}